@@ -1,235 +1,160 @@
 package main
 
-import "ayu"
-import "bufio"
-import "bytes"
-import "os/exec"
-import "errors"
+import "ayu/player"
 import "flag"
 import "fmt"
-import "io"
 import "io/ioutil"
-import "log"
-import "encoding/json"
-import "net/http"
-import "net/url"
 import "os"
-import "path"
 import "strings"
+import "sync"
 
-var url_arg = flag.String("url", "", "Game URL with exactly one player key")
+var game_urls urlList
+var urls_file_arg = flag.String("urls_file", "", "File with game URLs, one per line")
+var create_arg = flag.String("create", "", "Create a new game on the server with this URL and print its links")
+var size_arg = flag.Int("size", 0, "Board size of the game to create (0 for the server's default)")
+var time_control_arg = flag.String("time_control", "", "Time control of the game to create, e.g. fischer:5m+3s, byoyomi:10m+5x30s or correspondence:3d")
+var difficulty_arg = flag.String("difficulty", "", "Difficulty of computer players in the game created with --create: beginner, easy, medium, hard or expert (passed to ayu protocol players as the Difficulty option)")
+var play_arg = flag.String("play", "", "Side to play in the game created with --create, or preferred with --queue: white or black")
+var queue_arg = flag.String("queue", "", "Join the matchmaking queue on the server with this URL and play the game found (preferences from --size, --time_control and --play)")
+var rating_arg = flag.Int("rating", 1500, "Rating used for matchmaking with --queue (reported as is; the server can't check it)")
 var player_arg = flag.String("player", "", "Command to run player program")
+var terminal_arg = flag.Bool("terminal", false, "Play yourself in the terminal instead of running a player program")
+var observe_arg = flag.Bool("observe", false, "Follow the games without playing; game URLs need no player key")
+var records_arg = flag.String("records", ".", "Directory where records of observed games are written")
+var color_arg = flag.Bool("color", true, "Use colours in the terminal")
+var protocol_arg = flag.String("protocol", "legacy", "Protocol spoken by player program: "+player.ProtocolNames)
+var options_arg player.OptionList
+var move_timeout_arg = flag.Duration("move_timeout", 0, "Maximum time the player may take per move (0 for its share of the clock, or 5m in games without one)")
+var time_arg = flag.Duration("time", 0, "Time each player has for the game, passed to the player program (ayu protocol only; 0 for none)")
+var increment_arg = flag.Duration("increment", 0, "Time added to a player's clock after each of its moves (with --time)")
+var restarts_arg = flag.Int("restarts", 0, "Number of times to restart the player if it crashes, hangs or misbehaves")
+var cpu_limit_arg = flag.Duration("cpu_limit", 0, "Maximum CPU time per player process (Linux only; 0 for no limit)")
+var memory_limit_arg = flag.Uint64("memory_limit", 0, "Maximum address space of the player process in MiB (Linux only; 0 for no limit)")
+var process_limit_arg = flag.Uint64("process_limit", 0, "Maximum number of processes and threads of the user running the player (Linux only; 0 for no limit)")
+var file_limit_arg = flag.Uint64("file_limit", 0, "Maximum number of open files per player process (Linux only; 0 for no limit)")
+var wall_limit_arg = flag.Duration("wall_limit", 0, "Kill the player process after this much time (0 for no limit)")
+var jail_arg = flag.String("jail", "", "Directory to run the player in (created if necessary)")
+var chroot_arg = flag.Bool("chroot", false, "Also make --jail the player's root directory (requires privileges)")
 
-// Parsed from --url argument
-var game_url url.URL
-var game_id, black_key, white_key *string
-
-// Fetched from server
-var game_state ayu.State
+func init() {
+	flag.Var(&game_urls, "url", "Game URL with exactly one player key, or with none but the game id for --observe (may be repeated)")
+	flag.Var(&options_arg, "option", "Engine option name=value (ayu protocol only; may be repeated)")
+}
 
-// Based on --player argument
-var player_cmd exec.Cmd
-var player_in io.WriteCloser
-var player_out, player_err io.ReadCloser
+// Game URLs passed with --url.
+type urlList []string
 
-func readStrings(input io.ReadCloser, delimiter byte, output chan<- string) {
-	reader := bufio.NewReader(input)
-	for {
-		if line, err := reader.ReadString(delimiter); line != "" {
-			output <- line
-		} else if err == io.EOF {
-			break
-		} else if err != nil {
-			log.Fatal("Error reading from player!", err)
-			break
-		}
-	}
-	fmt.Println("Exiting")
-	input.Close()
+func (l *urlList) String() string {
+	return strings.Join(*l, " ")
 }
 
-func relativePathToUrl(rel_path string) url.URL {
-	return url.URL{
-		game_url.Scheme, game_url.Opaque, game_url.User, game_url.Host,
-		path.Join(path.Dir(game_url.Path), rel_path), "", ""}
+func (l *urlList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
 }
 
-func pollGame(version int) error {
-	params := url.Values{}
-	params.Set("game", *game_id)
-	params.Set("version", fmt.Sprintf("%d", version))
-	poll_url := relativePathToUrl("poll")
-	poll_url.RawQuery = params.Encode()
-poll:
-	if response, err := http.Get(poll_url.String()); err != nil {
-		return err
-	} else {
-		defer response.Body.Close()
-		if response.StatusCode == 200 /* OK */ {
-			if body, err := ioutil.ReadAll(response.Body); err != nil {
-				return err
-			} else {
-				var state struct {
-					NextPlayer int
-					Size       int
-					Fields     ayu.Fields
-					History    ayu.History
-				}
-				if err := json.Unmarshal(body, &state); err != nil {
-					return err
-				}
-				if state.Size != ayu.DefaultSize {
-					return fmt.Errorf(
-						"Unexpected board size: %d (expected: %d)",
-						state.Size, ayu.DefaultSize)
-				}
-				if len(state.History) != version {
-					return fmt.Errorf(
-						"Unexpected number of moves: %d (expected: %d)",
-						len(state.History), version)
-				}
-				game_state = ayu.State{state.Fields, state.History}
-				return nil
-			}
-		} else if response.StatusCode == 204 /* No Content */ {
-			// TODO: rate limit polling!
-			goto poll
-		} else {
-			return errors.New(fmt.Sprintf(
-				"Unexpected response status: %s",
-				response.Status))
+// Reads game URLs from a file, one per line.  Empty lines and lines
+// starting with # are ignored.
+func readUrlsFile(filename string) (urls []string, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
 		}
 	}
+	return urls, nil
 }
 
-func parseGameUrl(url_str string) error {
-	if the_url, err := url.Parse(url_str); err != nil {
-		return errors.New("Could not parse game URL.")
-	} else if fragment_map, err := url.ParseQuery(the_url.Fragment); err != nil {
-		return errors.New("Could not parse URL fragment.")
-	} else {
-		num_keys := 0
-		num_games := 0
-		for key, values := range fragment_map {
-			for _, value := range values {
-				switch key {
-				case "game":
-					game_id = &value
-					num_games++
-				case "white":
-					white_key = &value
-					num_keys++
-				case "black":
-					black_key = &value
-					num_keys++
-				}
-			}
-		}
-		if num_games != 1 {
-			return errors.New("Need exactly one game id.")
-		}
-		if num_keys != 1 {
-			return errors.New("Need exactly one player key.")
+func main() {
+	flag.Parse()
+	if *urls_file_arg != "" {
+		if urls, err := readUrlsFile(*urls_file_arg); err != nil {
+			fmt.Println("Could not read URLs file!", err)
+			os.Exit(1)
+		} else {
+			game_urls = append(game_urls, urls...)
 		}
-		game_url = *the_url
-		return nil
 	}
-}
-
-func runPlayerCommand(command string) error {
-	if argv := strings.Fields(*player_arg); len(argv) == 0 {
-		return errors.New("No player command given!")
-	} else if name, err := exec.LookPath(argv[0]); err != nil {
-		return errors.New("Can't find player executable!")
-	} else if dir, err := os.Getwd(); err != nil {
-		return errors.New("Can't get current working directory!")
-	} else {
-		player_cmd = exec.Cmd{Path: name, Args: argv, Dir: dir}
-		if player_in, err = player_cmd.StdinPipe(); err != nil {
-			return errors.New("Could not open player input!")
-		} else if player_out, err = player_cmd.StdoutPipe(); err != nil {
-			return errors.New("Could not open player output!")
-		} else if player_err, err = player_cmd.StderrPipe(); err != nil {
-			return errors.New("Could not open player errors!")
-		} else if err := player_cmd.Start(); err != nil {
-			return errors.New("Could not start player!")
+	if *play_arg != "" && *create_arg == "" && *queue_arg == "" {
+		fmt.Println("--play can only be used with --create or --queue!")
+		os.Exit(1)
+	}
+	if *difficulty_arg != "" && *create_arg == "" {
+		fmt.Println("--difficulty can only be used with --create!")
+		os.Exit(1)
+	}
+	if *create_arg != "" && *queue_arg != "" {
+		fmt.Println("Can't both create a game and join the queue!")
+		os.Exit(1)
+	}
+	if *create_arg != "" {
+		if link, err := createGameFromArgs(); err != nil {
+			fmt.Println("Could not create game!", err)
+			os.Exit(1)
+		} else if link != "" {
+			game_urls = append(game_urls, link)
+		} else if len(game_urls) == 0 {
+			return
 		}
-		return nil
 	}
-}
-
-func postLastMove() error {
-	key := ""
-	if white_key != nil {
-		key = *white_key
-	} else if black_key != nil {
-		key = *black_key
+	if *queue_arg != "" {
+		if link, err := queueFromArgs(); err != nil {
+			fmt.Println("Could not join the queue!", err)
+			os.Exit(1)
+		} else {
+			game_urls = append(game_urls, link)
+		}
 	}
-	version := len(game_state.History) - 1
-	update := map[string]interface{}{
-		"game":    game_id,
-		"version": version,
-		"key":     key,
-		"move":    game_state.History[version],
+	if len(game_urls) == 0 {
+		fmt.Println("No game URLs given!")
+		os.Exit(1)
 	}
-	update_url := relativePathToUrl("update")
-	if update_bytes, err := json.Marshal(update); err != nil {
-		return err
-	} else if response, err := http.Post(update_url.String(), "application/json",
-			bytes.NewReader(update_bytes)); err != nil {
-		return err
-	} else if response.StatusCode != 200 {
-		return fmt.Errorf("Unexpected response status: %d %s",
-			response.StatusCode, response.Status)
+	var games []*gameClient
+	for _, url_str := range game_urls {
+		if g, err := newGameClient(url_str); err != nil {
+			fmt.Println("Could not parse game URL!", url_str, err)
+			os.Exit(1)
+		} else {
+			games = append(games, g)
+		}
 	}
-	return nil
-}
-
-func main() {
-	flag.Parse()
-	if err := parseGameUrl(*url_arg); err != nil {
-		fmt.Println("Could not parse game URL!", err)
-		return
+	if *terminal_arg && *observe_arg {
+		fmt.Println("Can't both play in the terminal and observe!")
+		os.Exit(1)
 	}
-	if err := runPlayerCommand(*player_arg); err != nil {
-		fmt.Println("Could not execute player command! ", err)
+	if *terminal_arg {
+		if len(games) != 1 {
+			fmt.Println("Can only play one game at a time in the terminal!")
+			os.Exit(1)
+		}
+		if err := games[0].playInTerminal(); err != nil {
+			os.Exit(1)
+		}
 		return
 	}
-	game_state.Create(ayu.DefaultSize)
-	go io.Copy(os.Stderr, player_err)
-	lines := make(chan string)
-	go readStrings(player_out, '\n', lines)
-	for !game_state.Over() {
-		if (game_state.Next() == 0) == (white_key != nil) {
-			// Player's turn
-			if len(game_state.History) == 0 {
-				player_in.Write([]byte("Start\n"))
+	var wg sync.WaitGroup
+	failed := false
+	var failed_mutex sync.Mutex
+	for _, g := range games {
+		wg.Add(1)
+		go func(g *gameClient) {
+			defer wg.Done()
+			play := g.play
+			if *observe_arg {
+				play = g.observe
 			}
-			move_str := strings.TrimSpace(<-lines)
-			if move, ok := ayu.ParseMove(move_str); !ok {
-				fmt.Println("Could not parse move:", move_str)
-				break
-			} else if !game_state.Execute(move) {
-				fmt.Println("Player made invalid move:", move_str)
-				break
-			} else if err := postLastMove(); err != nil {
-				fmt.Printf("Failed to post move '%s': %s\n", move, err)
-				break
-			} else {
-				fmt.Println(">", move)
+			if err := play(); err != nil {
+				failed_mutex.Lock()
+				failed = true
+				failed_mutex.Unlock()
 			}
-		} else {
-			// Opponent's turn
-			if err := pollGame(len(game_state.History) + 1); err != nil {
-				fmt.Println("Could not poll game state!", err)
-				break
-			}
-			last_move := game_state.History[len(game_state.History)-1]
-			fmt.Println("<", last_move)
-			player_in.Write([]byte(last_move.String() + "\n"))
-		}
+		}(g)
+	}
+	wg.Wait()
+	if failed {
+		os.Exit(1)
 	}
-	fmt.Println("Game over.")
-	player_in.Write([]byte("Quit\n"))
-	player_cmd.Wait()
 }
@@ -0,0 +1,160 @@
+package main
+
+import "ayu"
+import "bytes"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io/ioutil"
+import "net/http"
+import "net/url"
+import "strconv"
+import "strings"
+import "time"
+
+// Response of the server's /create handler.
+type createdGame struct {
+	Game string
+	Keys [2]string
+	Size int
+}
+
+// Parses a time control given as type:initial[+extra], where extra is the
+// increment (fischer), delay (bronstein) or periods x period (byoyomi), e.g.
+// "sudden death:10m", "fischer:5m+3s", "byoyomi:10m+5x30s", or as
+// "correspondence:<days>d".
+func parseTimeControl(spec string) (*timeControl, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	tc := &timeControl{Type: parts[0]}
+	if len(parts) < 2 {
+		return nil, errors.New("Missing times in time control.")
+	}
+	if tc.Type == "correspondence" {
+		if _, err := fmt.Sscanf(parts[1], "%dd", &tc.Days); err != nil {
+			return nil, errors.New("Could not parse days per move.")
+		}
+		return tc, nil
+	}
+	times := strings.SplitN(parts[1], "+", 2)
+	if initial, err := time.ParseDuration(times[0]); err != nil {
+		return nil, err
+	} else {
+		tc.Initial = initial.Seconds()
+	}
+	if len(times) < 2 {
+		return tc, nil
+	}
+	extra := times[1]
+	if tc.Type == "byoyomi" {
+		if i := strings.Index(extra, "x"); i < 0 {
+			return nil, errors.New("Byo-yomi needs periods x period, e.g. 5x30s.")
+		} else if periods, err := strconv.Atoi(extra[:i]); err != nil {
+			return nil, err
+		} else {
+			tc.Periods = periods
+			extra = extra[i+1:]
+		}
+	}
+	d, err := time.ParseDuration(extra)
+	if err != nil {
+		return nil, err
+	}
+	switch tc.Type {
+	case "fischer":
+		tc.Increment = d.Seconds()
+	case "bronstein":
+		tc.Delay = d.Seconds()
+	case "byoyomi":
+		tc.Period = d.Seconds()
+	default:
+		return nil, fmt.Errorf("Time control %q takes no extra time.", tc.Type)
+	}
+	return tc, nil
+}
+
+// Creates a new game on the server at base_url, e.g. "http://host/".
+func createGame(base_url *url.URL, size int, tc *timeControl, difficulty string) (*createdGame, error) {
+	create_url := base_url.ResolveReference(&url.URL{Path: "create"})
+	create_bytes, err := json.Marshal(map[string]interface{}{"size": size, "timeControl": tc, "difficulty": difficulty})
+	if err != nil {
+		return nil, err
+	}
+	response, err := doWithRetries(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", create_url.String(), bytes.NewReader(create_bytes))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("Unexpected response status: %s\n%s", response.Status, body)
+	}
+	var created createdGame
+	if err := json.Unmarshal(body, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// Returns the link to the game page with the given keys, in the same format
+// as create.js: index 0 is for spectators, 1 for white, 2 for black and 3
+// for both players.
+func (c *createdGame) link(base_url *url.URL, i int) string {
+	fragment := "game=" + url.QueryEscape(c.Game)
+	if i&1 != 0 {
+		fragment += "&white=" + url.QueryEscape(c.Keys[0])
+	}
+	if i&2 != 0 {
+		fragment += "&black=" + url.QueryEscape(c.Keys[1])
+	}
+	fragment += fmt.Sprintf("&size=%d", c.Size)
+	return base_url.ResolveReference(&url.URL{Path: "game.html"}).String() + "#" + fragment
+}
+
+// Creates a game as requested by --create, prints its links, and returns
+// the link for the side given by --play, if any.
+func createGameFromArgs() (string, error) {
+	base_url, err := url.Parse(*create_arg)
+	if err != nil {
+		return "", errors.New("Could not parse server URL.")
+	}
+	play := -1
+	switch *play_arg {
+	case "":
+	case "white":
+		play = 1
+	case "black":
+		play = 2
+	default:
+		return "", errors.New("Side to play must be white or black.")
+	}
+	if *size_arg != 0 && !ayu.IsValidSize(*size_arg) {
+		return "", fmt.Errorf("Invalid board size: %d", *size_arg)
+	}
+	var tc *timeControl
+	if *time_control_arg != "" {
+		if tc, err = parseTimeControl(*time_control_arg); err != nil {
+			return "", fmt.Errorf("Invalid time control: %s", err)
+		}
+	}
+	created, err := createGame(base_url, *size_arg, tc, *difficulty_arg)
+	if err != nil {
+		return "", err
+	}
+	fmt.Println("Spectators:", created.link(base_url, 0))
+	fmt.Println("White:     ", created.link(base_url, 1))
+	fmt.Println("Black:     ", created.link(base_url, 2))
+	fmt.Println("Both:      ", created.link(base_url, 3))
+	if play < 0 {
+		return "", nil
+	}
+	return created.link(base_url, play), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import "bufio"
+import "encoding/json"
+import "flag"
+import "fmt"
+import "io"
+import "net/http"
+import "net/url"
+import "strings"
+
+var events_arg = flag.Bool("events", false, "Follow games through the server's event stream instead of polling")
+
+// A server-sent event stream for a game, kept open between moves.
+type eventStream struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+}
+
+func (g *gameClient) openEvents() error {
+	params := url.Values{}
+	params.Set("game", g.id)
+	events_url := g.relativePathToUrl("events")
+	events_url.RawQuery = params.Encode()
+	response, err := doWithRetries(func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", events_url.String(), nil)
+		if req != nil && g.last_event_id != "" {
+			req.Header.Set("Last-Event-ID", g.last_event_id)
+		}
+		return req, err
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != 200 {
+		response.Body.Close()
+		return fmt.Errorf("Unexpected response status: %s", response.Status)
+	}
+	g.events = &eventStream{response.Body, bufio.NewReader(response.Body)}
+	return nil
+}
+
+func (g *gameClient) closeEvents() {
+	if g.events != nil {
+		g.events.body.Close()
+		g.events = nil
+	}
+}
+
+// Reads the next event, skipping comments.  Returns its id and data.
+func (s *eventStream) read() (id, data string, err error) {
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return "", "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if data != "" {
+				return id, data, nil
+			}
+		case strings.HasPrefix(line, "id:"):
+			id = strings.TrimSpace(line[3:])
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(line[5:])
+		}
+	}
+}
+
+// Like fetchGame, but waits for the game state on the event stream, which
+// is opened on first use and reopened after errors.  The stream is
+// resumed from the last event received, so no updates are missed.
+func (g *gameClient) fetchEvents(version int) (*polledState, error) {
+	for {
+		if g.events == nil {
+			g.waitToPoll()
+			if err := g.openEvents(); err != nil {
+				return nil, err
+			}
+		}
+		id, data, err := g.events.read()
+		if err != nil {
+			// The connection was dropped or timed out; reconnect.  Errors
+			// while reconnecting are retried by doWithRetries.
+			g.closeEvents()
+			continue
+		}
+		var state polledState
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			return nil, err
+		}
+		g.last_event_id = id
+		// Like /poll, also return if moves were taken back.
+		if len(state.History) >= version || len(state.History) < version-1 || state.Result != nil {
+			return &state, nil
+		}
+	}
+}
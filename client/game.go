@@ -0,0 +1,622 @@
+package main
+
+import "ayu"
+import "ayu/player"
+import "bytes"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io/ioutil"
+import "net/http"
+import "net/url"
+import "os"
+import "path"
+import "strings"
+import "time"
+
+// A single game played by the client, with its own player program.
+type gameClient struct {
+	// Parsed from the game URL
+	url                  url.URL
+	id                   string
+	black_key, white_key *string
+
+	// Fetched from server
+	state        ayu.State
+	time_used    [2]time.Duration
+	time_left    *[2]time.Duration // nil if the game has no time control
+	periods_left *[2]int           // byo-yomi periods, nil for other time controls
+	time_control *timeControl
+	result       *gameResult // nil while the game is in progress
+	draw_offer   int         // player offering a draw: +1 (white), -1 (black) or 0
+	difficulty   string      // requested strength of computer players, if any
+	polled_at    time.Time   // when the above was fetched
+	taken_back   int         // moves taken back since the previous poll
+
+	// Based on --player argument
+	proc          *player.Process
+	restarts_left int
+
+	last_poll time.Time // used to limit the polling rate
+
+	// Used with --events
+	events        *eventStream
+	last_event_id string
+}
+
+func (g *gameClient) relativePathToUrl(rel_path string) url.URL {
+	return url.URL{
+		Scheme: g.url.Scheme, Opaque: g.url.Opaque,
+		User: g.url.User, Host: g.url.Host,
+		Path: path.Join(path.Dir(g.url.Path), rel_path)}
+}
+
+// Game state as returned by the server's /poll handler.
+type polledState struct {
+	NextPlayer  int
+	Size        int
+	Fields      ayu.Fields
+	History     ayu.History
+	TimeUsed    [2]float64  // in seconds, for white and black
+	TimeLeft    *[2]float64 // in seconds, only with a time control
+	PeriodsLeft *[2]int     // only with byo-yomi
+	TimeControl *timeControl
+	Result      *gameResult
+	DrawOffer   int
+	Difficulty  string
+}
+
+// Time control of a game, as reported by the server.  Times are in seconds.
+type timeControl struct {
+	Type      string  `json:"type"`
+	Initial   float64 `json:"initial,omitempty"`
+	Increment float64 `json:"increment,omitempty"`
+	Delay     float64 `json:"delay,omitempty"`
+	Periods   int     `json:"periods,omitempty"`
+	Period    float64 `json:"period,omitempty"`
+	Days      int     `json:"days,omitempty"`
+}
+
+// How a game ended.  Winner is +1 (white), -1 (black) or 0 (draw).
+type gameResult struct {
+	Winner int
+	Reason string
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Fetches the game state once it has reached the given version, i.e. once
+// at least that many moves have been played.
+func (g *gameClient) fetchGame(version int) (*polledState, error) {
+	if *events_arg && version > 0 {
+		return g.fetchEvents(version)
+	}
+	params := url.Values{}
+	params.Set("game", g.id)
+	params.Set("version", fmt.Sprintf("%d", version))
+	poll_url := g.relativePathToUrl("poll")
+	poll_url.RawQuery = params.Encode()
+	for {
+		g.waitToPoll()
+		response, err := doWithRetries(func() (*http.Request, error) {
+			return http.NewRequest("GET", poll_url.String(), nil)
+		}, g.waitToPoll)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		switch response.StatusCode {
+		case 200: // OK
+			var state polledState
+			if err := json.Unmarshal(body, &state); err != nil {
+				return nil, err
+			}
+			return &state, nil
+		case 204: // No Content; the server timed out waiting for an update.
+			continue
+		default:
+			return nil, fmt.Errorf("Unexpected response status: %s", response.Status)
+		}
+	}
+}
+
+func (g *gameClient) pollGame(version int) error {
+	state, err := g.fetchGame(version)
+	if err != nil {
+		return err
+	}
+	if !ayu.IsValidSize(state.Size) || len(state.Fields) != state.Size {
+		return fmt.Errorf("Invalid board size: %d", state.Size)
+	}
+	if g.state.Fields != nil && len(g.state.Fields) != state.Size {
+		return fmt.Errorf(
+			"Unexpected board size: %d (expected: %d)",
+			state.Size, len(g.state.Fields))
+	}
+	// Our moves that are no longer in the server's history were taken
+	// back; other moves may have been played since.
+	common := 0
+	for common < len(g.state.History) && common < len(state.History) &&
+		g.state.History[common] == state.History[common] {
+		common++
+	}
+	taken_back := len(g.state.History) - common
+	if len(state.History) < version && state.Result == nil && taken_back == 0 {
+		return fmt.Errorf(
+			"Unexpected number of moves: %d (expected: %d)",
+			len(state.History), version)
+	}
+	g.taken_back = taken_back
+	g.state = ayu.State{Fields: state.Fields, History: state.History}
+	for i, s := range state.TimeUsed {
+		g.time_used[i] = seconds(s)
+	}
+	if state.TimeLeft != nil {
+		g.time_left = &[2]time.Duration{seconds(state.TimeLeft[0]), seconds(state.TimeLeft[1])}
+	}
+	g.periods_left = state.PeriodsLeft
+	g.time_control = state.TimeControl
+	g.result = state.Result
+	g.draw_offer = state.DrawOffer
+	g.difficulty = state.Difficulty
+	g.polled_at = time.Now()
+	return nil
+}
+
+// Called when posting a move failed.  Takes back the move and returns
+// whether that was because the game ended in the meantime, e.g. because
+// the player ran out of time.  Otherwise, taken_back tells whether moves
+// were taken back meanwhile.
+func (g *gameClient) endedMeanwhile() bool {
+	g.state.Undo()
+	return g.pollGame(0) == nil && g.over()
+}
+
+// Returns whether the game is over, on the board or otherwise.
+func (g *gameClient) over() bool {
+	return g.result != nil || g.state.Over()
+}
+
+// Returns how the game ended.  Must only be called once it is over.
+func (g *gameClient) gameResult() gameResult {
+	if g.result != nil {
+		return *g.result
+	}
+	next := g.state.Next()
+	return gameResult{g.state.NextPlayer(), color_names[next] + " has no moves left"}
+}
+
+func newGameClient(url_str string) (*gameClient, error) {
+	g := &gameClient{restarts_left: *restarts_arg}
+	if the_url, err := url.Parse(url_str); err != nil {
+		return nil, errors.New("Could not parse game URL.")
+	} else if fragment_map, err := url.ParseQuery(the_url.Fragment); err != nil {
+		return nil, errors.New("Could not parse URL fragment.")
+	} else {
+		num_keys := 0
+		num_games := 0
+		for key, values := range fragment_map {
+			for _, value := range values {
+				switch key {
+				case "game":
+					g.id = value
+					num_games++
+				case "white":
+					g.white_key = &value
+					num_keys++
+				case "black":
+					g.black_key = &value
+					num_keys++
+				}
+			}
+		}
+		if num_games != 1 {
+			return nil, errors.New("Need exactly one game id.")
+		}
+		if num_keys != 1 && !*observe_arg {
+			return nil, errors.New("Need exactly one player key.")
+		}
+		g.url = *the_url
+		return g, nil
+	}
+}
+
+// Returns the key of the player we play for.
+func (g *gameClient) key() string {
+	if g.white_key != nil {
+		return *g.white_key
+	} else if g.black_key != nil {
+		return *g.black_key
+	}
+	return ""
+}
+
+func (g *gameClient) postLastMove() error {
+	version := len(g.state.History) - 1
+	update := map[string]interface{}{
+		"game":    g.id,
+		"version": version,
+		"key":     g.key(),
+		"move":    g.state.History[version],
+	}
+	update_url := g.relativePathToUrl("update")
+	update_bytes, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	retried := false
+	response, err := doWithRetries(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", update_url.String(), bytes.NewReader(update_bytes))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, err
+	}, func() { retried = true })
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode == 409 && retried {
+		// An earlier attempt may have reached the server even though we
+		// didn't get its response.  If so, the move has been played.
+		if state, err := g.fetchGame(0); err != nil {
+			return err
+		} else if len(state.History) > version && state.History[version] == g.state.History[version] {
+			return nil
+		}
+	}
+	if response.StatusCode != 200 {
+		return fmt.Errorf("Unexpected response status: %d %s",
+			response.StatusCode, response.Status)
+	}
+	return nil
+}
+
+// Sends an action such as "resign" to the server on behalf of our player.
+func (g *gameClient) postAction(action string) error {
+	action_url := g.relativePathToUrl(action)
+	action_bytes, err := json.Marshal(map[string]interface{}{"game": g.id, "key": g.key()})
+	if err != nil {
+		return err
+	}
+	retried := false
+	response, err := doWithRetries(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", action_url.String(), bytes.NewReader(action_bytes))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, err
+	}, func() { retried = true })
+	if err != nil {
+		return err
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode == 403 && retried {
+		// An earlier attempt may have ended the game already.
+		if state, err := g.fetchGame(0); err == nil && state.Result != nil {
+			return nil
+		}
+	}
+	if response.StatusCode != 200 {
+		return fmt.Errorf("Unexpected response status: %s\n%s", response.Status, body)
+	}
+	return nil
+}
+
+func playerLimits() *player.Limits {
+	return &player.Limits{
+		CPUTime:      *cpu_limit_arg,
+		AddressSpace: *memory_limit_arg << 20,
+		Processes:    *process_limit_arg,
+		OpenFiles:    *file_limit_arg,
+		WallClock:    *wall_limit_arg,
+		Dir:          *jail_arg,
+		Chroot:       *chroot_arg,
+	}
+}
+
+// Returns the options to pass to the player program: those given with
+// --option, and the game's difficulty unless that was set explicitly.
+func (g *gameClient) playerOptions() player.OptionList {
+	options := append(player.OptionList{}, options_arg...)
+	if g.difficulty == "" || *protocol_arg != "ayu" {
+		return options
+	}
+	for _, opt := range options {
+		if strings.EqualFold(opt[0], "difficulty") {
+			return options
+		}
+	}
+	return append(options, [2]string{"Difficulty", g.difficulty})
+}
+
+// Starts the player program and tells it about the game so far.
+func (g *gameClient) startPlayer() error {
+	if p, err := player.StartWithLimits(*player_arg, *protocol_arg, g.playerOptions(), os.Stderr, playerLimits()); err != nil {
+		return err
+	} else {
+		g.proc = p
+	}
+	if err := g.proc.NewGame(len(g.state.Fields)); err != nil {
+		g.proc.Kill()
+		return err
+	}
+	if len(g.state.History) > 0 {
+		if err := g.proc.Resume(&g.state, g.color()); err != nil {
+			g.proc.Kill()
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the colour we play: 0 for white, 1 for black.
+func (g *gameClient) color() int {
+	if g.white_key != nil {
+		return 0
+	}
+	return 1
+}
+
+// Describes the moves taken back since the previous poll.
+func (g *gameClient) takenBackMessage() string {
+	if g.taken_back == 1 {
+		return "1 move taken back"
+	}
+	return fmt.Sprintf("%d moves taken back", g.taken_back)
+}
+
+// Returned by movesTakenBack when the player can't continue after a
+// takeback.  The takeback was accepted on the player's behalf, so the client
+// stops playing without resigning.
+var errCannotTakeBack = errors.New("Player can't follow the takeback with the legacy protocol.")
+
+// Sets up the player for the current position after moves were taken
+// back, restarting it if necessary.  Legacy players can't be told about
+// takebacks, so they are restarted, which only works if the game can be
+// resumed with that protocol.
+func (g *gameClient) movesTakenBack() error {
+	g.println(g.takenBackMessage() + ".")
+	if *protocol_arg != "legacy" {
+		if err := g.proc.Resume(&g.state, g.color()); err != nil {
+			return g.restartPlayer(err)
+		}
+		return nil
+	}
+	g.proc.Kill()
+	if n := len(g.state.History); n > 1 || n == 1 && g.color() == 0 {
+		g.println(errCannotTakeBack, "Stopped playing without resigning.")
+		return errCannotTakeBack
+	}
+	if err := g.startPlayer(); err != nil {
+		return g.restartPlayer(err)
+	}
+	return nil
+}
+
+// Kills the player program after it failed, and restarts it if --restarts
+// allows it.  Returns an error if the player can't continue.  A player
+// that exceeded its resource limits forfeits and is never restarted.
+func (g *gameClient) restartPlayer(failure error) error {
+	g.proc.Kill()
+	if limit := g.proc.LimitExceeded(); limit != "" {
+		return fmt.Errorf("Player exceeded its %s.", limit)
+	}
+	for g.restarts_left > 0 {
+		g.restarts_left--
+		g.println("Restarting player after failure:", failure)
+		if failure = g.startPlayer(); failure == nil {
+			return nil
+		}
+	}
+	return failure
+}
+
+// A player is stopped once it has used the time left on its clock divided
+// by this many moves, plus the increment.  Players that manage their own
+// time should stay well within that.
+const moves_to_go = 10
+
+// Time kept in reserve when a player is stopped, for its answer and for
+// posting the move before the flag falls.
+const move_time_margin = player.StopGrace + 500*time.Millisecond
+
+// Shortest time limit for a move, even when the clock has almost run out.
+const min_move_time = 100 * time.Millisecond
+
+// Time limit for a move in games without a clock, unless --move_timeout is
+// given, so that a hung player doesn't block the game forever.
+const default_move_timeout = 5 * time.Minute
+
+// Returns how long the player may think about its next move: its share of
+// the time left on the clock, but never so long that it would lose on
+// time, and at most --move_timeout.
+func (g *gameClient) moveTimeLimit() time.Duration {
+	limit := *move_timeout_arg
+	clock := g.clock()
+	if clock == nil {
+		if limit <= 0 {
+			limit = default_move_timeout
+		}
+		return limit
+	}
+	p := g.state.Next()
+	budget := clock.Left[p]/moves_to_go + clock.Increment[p]
+	available := clock.Left[p]
+	if g.periods_left != nil && g.time_control != nil && g.periods_left[p] > 0 {
+		// A byo-yomi period can be used on every move.  Once in
+		// overtime, the time left is that of the current period, so
+		// only the periods after it are certain to be available.
+		period := seconds(g.time_control.Period)
+		budget += period
+		available += time.Duration(g.periods_left[p]-1) * period
+	}
+	if budget > available-move_time_margin {
+		budget = available - move_time_margin
+	}
+	if budget < min_move_time {
+		budget = min_move_time
+	}
+	if limit <= 0 || budget < limit {
+		limit = budget
+	}
+	return limit
+}
+
+// Returns the time left on both players' clocks, or nil if there is no
+// time control.  Time controls enforced by the server take precedence over
+// --time and --increment, for which the time left is derived from the time
+// used.  Either way, the time that passed since the last poll is included.
+func (g *gameClient) clock() *player.Clock {
+	if g.time_left != nil {
+		clock := &player.Clock{Left: g.timeLeft()}
+		if tc := g.time_control; tc != nil {
+			// Engines only know about increments; a delay is close enough.
+			increment := seconds(tc.Increment + tc.Delay)
+			clock.Increment = [2]time.Duration{increment, increment}
+		}
+		return clock
+	}
+	if *time_arg <= 0 {
+		return nil
+	}
+	clock := &player.Clock{Increment: [2]time.Duration{*increment_arg, *increment_arg}}
+	used := g.clocks()
+	for i := range clock.Left {
+		moves_made := (len(g.state.History) + 1 - i) / 2
+		clock.Left[i] = *time_arg + time.Duration(moves_made)*(*increment_arg) - used[i]
+		if clock.Left[i] < 0 {
+			clock.Left[i] = 0
+		}
+	}
+	return clock
+}
+
+// Asks the player for a valid move, restarting it if necessary.
+func (g *gameClient) selectMove() (ayu.Move, error) {
+	for {
+		move, err := player.SelectMoveWithin(g.proc, &g.state, g.clock(), g.moveTimeLimit())
+		if err == nil && !g.state.Valid(move) {
+			err = fmt.Errorf("Player made invalid move: %s", move)
+		}
+		if err == nil {
+			return move, nil
+		}
+		if err := g.restartPlayer(err); err != nil {
+			return ayu.Move{}, err
+		}
+	}
+}
+
+// Tells the player about the opponent's last move, restarting it if
+// necessary.
+func (g *gameClient) opponentMoved() error {
+	for {
+		err := g.proc.OpponentMoved(&g.state)
+		if err == nil {
+			return nil
+		}
+		if err := g.restartPlayer(err); err != nil {
+			return err
+		}
+	}
+}
+
+// Called when the player can't continue the game, because it failed or
+// exceeded its resource limits.  Resigns the game, so that the opponent
+// doesn't have to wait for our clock to run out.
+func (g *gameClient) giveUp(err error) error {
+	g.println("Player can't continue!", err)
+	if err := g.postAction("resign"); err != nil {
+		g.println("Could not resign!", err)
+	} else {
+		g.println("Resigned.")
+	}
+	return errors.New("Gave up on the game.")
+}
+
+// Prints a line of output, prefixed with the game id if the client is
+// playing more than one game.
+func (g *gameClient) println(args ...interface{}) {
+	if len(game_urls) > 1 {
+		args = append([]interface{}{"[" + g.id + "]"}, args...)
+	}
+	fmt.Println(args...)
+}
+
+// Plays the game until it is over, or until something goes wrong.
+func (g *gameClient) play() error {
+	defer g.closeEvents()
+	// Fetch the current state, to learn the board size and any moves that
+	// have already been played, e.g. when resuming after a crash.
+	if err := g.pollGame(0); err != nil {
+		g.println("Could not fetch game state!", err)
+		return err
+	}
+	if len(g.state.History) > 0 {
+		g.println(fmt.Sprintf("Resuming game after %d moves.", len(g.state.History)))
+	}
+	if err := g.startPlayer(); err != nil {
+		g.println("Could not start player!", err)
+		return err
+	}
+	defer g.proc.Close(5 * time.Second)
+	for !g.over() {
+		if (g.state.Next() == 0) == (g.white_key != nil) {
+			// Player's turn
+			start := time.Now()
+			move, err := g.selectMove()
+			if err != nil {
+				return g.giveUp(err)
+			}
+			g.state.Execute(move)
+			if err := g.postLastMove(); err != nil {
+				if g.endedMeanwhile() {
+					break
+				}
+				if g.taken_back > 0 {
+					if err := g.movesTakenBack(); err == errCannotTakeBack {
+						return err
+					} else if err != nil {
+						return g.giveUp(err)
+					}
+					continue
+				}
+				g.println(fmt.Sprintf("Failed to post move '%s': %s", move, err))
+				return err
+			}
+			g.println(">", move, fmt.Sprintf("(%.1fs)", time.Since(start).Seconds()))
+		} else {
+			// Opponent's turn
+			if err := g.pollGame(len(g.state.History) + 1); err != nil {
+				g.println("Could not poll game state!", err)
+				return err
+			}
+			if g.result != nil {
+				break
+			}
+			if g.taken_back > 0 {
+				if err := g.movesTakenBack(); err == errCannotTakeBack {
+					return err
+				} else if err != nil {
+					return g.giveUp(err)
+				}
+				continue
+			}
+			last_move := g.state.History[len(g.state.History)-1]
+			g.println("<", last_move)
+			if err := g.opponentMoved(); err != nil {
+				return g.giveUp(err)
+			}
+		}
+	}
+	g.println("Game over:", g.gameResult().Reason)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import "flag"
+import "fmt"
+import "log"
+import "math/rand"
+import "net/http"
+import "time"
+
+var retry_for_arg = flag.Duration("retry_for", 30*time.Minute, "How long to keep retrying after network or server errors")
+var min_poll_interval_arg = flag.Duration("min_poll_interval", time.Second, "Minimum time between consecutive poll requests")
+
+// Poll requests may block on the server for up to a minute.
+var http_client = http.Client{Timeout: 2 * time.Minute}
+
+// Exponential backoff with jitter between retries.
+type backoff struct {
+	delay time.Duration
+	start time.Time // time of the first failure
+}
+
+const min_backoff = 500 * time.Millisecond
+const max_backoff = time.Minute
+
+// Waits before the next attempt.  Returns false if we have been retrying
+// for longer than --retry_for and should give up.
+func (b *backoff) wait() bool {
+	if b.start.IsZero() {
+		b.start = time.Now()
+		b.delay = min_backoff
+	} else if b.delay *= 2; b.delay > max_backoff {
+		b.delay = max_backoff
+	}
+	if time.Since(b.start) > *retry_for_arg {
+		return false
+	}
+	// Sleep somewhere between half and all of the current delay, so that
+	// clients that failed at the same time don't retry at the same time.
+	time.Sleep(b.delay/2 + time.Duration(rand.Int63n(int64(b.delay/2))))
+	return true
+}
+
+// Sends requests created by request until one succeeds without a network
+// error or 5xx server error, retrying with exponential backoff.  Calls
+// retried before each retry.  The caller must close the response body.
+func doWithRetries(request func() (*http.Request, error), retried func()) (*http.Response, error) {
+	var b backoff
+	for {
+		req, err := request()
+		if err != nil {
+			return nil, err
+		}
+		response, err := http_client.Do(req)
+		if err == nil && response.StatusCode < 500 {
+			return response, nil
+		}
+		if err == nil {
+			response.Body.Close()
+			err = fmt.Errorf("Unexpected response status: %s", response.Status)
+		}
+		log.Printf("%s %s failed: %s", req.Method, req.URL.Path, err)
+		if !b.wait() {
+			return nil, err
+		}
+		if retried != nil {
+			retried()
+		}
+	}
+}
+
+// Limits the rate of consecutive poll requests for a game.
+func (g *gameClient) waitToPoll() {
+	if wait := *min_poll_interval_arg - time.Since(g.last_poll); wait > 0 {
+		time.Sleep(wait)
+	}
+	g.last_poll = time.Now()
+}
@@ -0,0 +1,86 @@
+package main
+
+import "ayu/player"
+import "bytes"
+import "fmt"
+import "os"
+import "path"
+import "strconv"
+import "strings"
+import "time"
+
+var color_names = [2]string{"White", "Black"}
+
+// Prints the current board and clocks of an observed game.  The screen is
+// written at once, so screens of different games don't get mixed up.
+func (g *gameClient) printScreen(heading string) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\nGame %s: %s\n", g.id, heading)
+	g.writeScreen(&buf)
+	os.Stdout.Write(buf.Bytes())
+}
+
+// Writes the record of a finished game to --records/<game id>.txt.
+func (g *gameClient) saveRecord(result player.Result) (string, error) {
+	filename := path.Join(*records_arg, g.id+".txt")
+	f, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	tags := [][2]string{
+		{"Game", g.id},
+		{"Date", time.Now().Format("2006.01.02")},
+		{"Size", strconv.Itoa(len(g.state.Fields))},
+		{"WhiteTime", formatClock(g.time_used[0])},
+		{"BlackTime", formatClock(g.time_used[1])},
+		{"Result", result.String()},
+	}
+	if err := player.WriteRecord(f, tags, &g.state); err != nil {
+		return "", err
+	}
+	return filename, f.Close()
+}
+
+// Follows the game without playing, printing every move, until the game is
+// over.  Then saves the game record.
+func (g *gameClient) observe() error {
+	defer g.closeEvents()
+	if err := g.pollGame(0); err != nil {
+		g.println("Could not fetch game state!", err)
+		return err
+	}
+	g.printScreen(fmt.Sprintf("observing after %d moves", len(g.state.History)))
+	for !g.over() {
+		seen := len(g.state.History)
+		if err := g.pollGame(seen + 1); err != nil {
+			g.println("Could not poll game state!", err)
+			return err
+		}
+		// More than one move may have been played since the last poll, or
+		// none if the game ended otherwise or moves were taken back.
+		var played []string
+		if g.taken_back > 0 {
+			played = append(played, g.takenBackMessage())
+		}
+		for i := seen - g.taken_back; i < len(g.state.History); i++ {
+			played = append(played, fmt.Sprintf("%s played %s", color_names[i%2], g.state.History[i]))
+		}
+		if len(played) > 0 {
+			g.printScreen(strings.Join(played, ", "))
+		}
+	}
+	game_result := g.gameResult()
+	result := player.Result{Winner: -1, Reason: game_result.Reason, State: &g.state}
+	if game_result.Winner != 0 {
+		result.Winner = (1 - game_result.Winner) / 2
+	}
+	g.println("Game over:", result)
+	if filename, err := g.saveRecord(result); err != nil {
+		g.println("Could not save game record!", err)
+		return err
+	} else {
+		g.println("Game record saved to", filename)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import "ayu"
+import "bytes"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io/ioutil"
+import "net/http"
+import "net/url"
+
+// Response of the server's /queue handler.
+type queuedSeek struct {
+	Seek   string
+	Secret string
+}
+
+// A player's place in a game found by the server, as returned by /seek.
+type seat struct {
+	Game  string
+	Key   string
+	Color string
+	Size  int
+}
+
+// Joins the matchmaking queue on the server at base_url with the
+// preferences given by --size, --time_control, --play and --rating.
+func joinQueue(base_url *url.URL) (*queuedSeek, error) {
+	if *size_arg != 0 && !ayu.IsValidSize(*size_arg) {
+		return nil, fmt.Errorf("Invalid board size: %d", *size_arg)
+	}
+	if *play_arg != "" && *play_arg != "white" && *play_arg != "black" {
+		return nil, errors.New("Side to play must be white or black.")
+	}
+	var tc *timeControl
+	if *time_control_arg != "" {
+		var err error
+		if tc, err = parseTimeControl(*time_control_arg); err != nil {
+			return nil, fmt.Errorf("Invalid time control: %s", err)
+		}
+	}
+	queue_url := base_url.ResolveReference(&url.URL{Path: "queue"})
+	queue_bytes, err := json.Marshal(map[string]interface{}{
+		"size": *size_arg, "timeControl": tc, "color": *play_arg, "rating": *rating_arg})
+	if err != nil {
+		return nil, err
+	}
+	response, err := doWithRetries(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", queue_url.String(), bytes.NewReader(queue_bytes))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("Unexpected response status: %s\n%s", response.Status, body)
+	}
+	var queued queuedSeek
+	if err := json.Unmarshal(body, &queued); err != nil {
+		return nil, err
+	}
+	return &queued, nil
+}
+
+// Waits until the server found an opponent, and returns our seat.
+func (q *queuedSeek) wait(base_url *url.URL) (*seat, error) {
+	params := url.Values{}
+	params.Set("seek", q.Seek)
+	params.Set("secret", q.Secret)
+	seek_url := base_url.ResolveReference(&url.URL{Path: "seek", RawQuery: params.Encode()})
+	for {
+		response, err := doWithRetries(func() (*http.Request, error) {
+			return http.NewRequest("GET", seek_url.String(), nil)
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		switch response.StatusCode {
+		case 200: // OK
+			var s seat
+			if err := json.Unmarshal(body, &s); err != nil {
+				return nil, err
+			}
+			return &s, nil
+		case 204: // No Content; still waiting for an opponent.
+			continue
+		default:
+			return nil, fmt.Errorf("Unexpected response status: %s\n%s", response.Status, body)
+		}
+	}
+}
+
+// Finds a game through the matchmaking queue as requested by --queue, and
+// returns its link with our player key.
+func queueFromArgs() (string, error) {
+	base_url, err := url.Parse(*queue_arg)
+	if err != nil {
+		return "", errors.New("Could not parse server URL.")
+	}
+	queued, err := joinQueue(base_url)
+	if err != nil {
+		return "", err
+	}
+	fmt.Println("Waiting for an opponent...")
+	s, err := queued.wait(base_url)
+	if err != nil {
+		return "", err
+	}
+	game := createdGame{Game: s.Game, Size: s.Size}
+	side := 1
+	if s.Color == "black" {
+		side = 2
+	}
+	game.Keys[side-1] = s.Key
+	link := game.link(base_url, side)
+	fmt.Printf("Playing %s: %s\n", s.Color, link)
+	return link, nil
+}
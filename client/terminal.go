@@ -0,0 +1,221 @@
+package main
+
+import "ayu"
+import "bufio"
+import "bytes"
+import "fmt"
+import "io"
+import "os"
+import "strings"
+import "time"
+
+// ANSI escape sequences used to colour the pieces.
+const (
+	white_color = "\x1b[1;37m"
+	black_color = "\x1b[1;31m"
+	label_color = "\x1b[2m"
+	reset_color = "\x1b[0m"
+)
+
+// Number of moves shown below the board; the full list is shown on request.
+const recent_log_lines = 3
+
+// Writes the board with the last row on top, as in the browser, with row
+// numbers on the left and column letters at the bottom.
+func writeBoard(w io.Writer, fields ayu.Fields, color bool) {
+	var buf bytes.Buffer
+	fields.WriteBoard(&buf)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	paint := func(color_code, s string) string {
+		if !color {
+			return s
+		}
+		return color_code + s + reset_color
+	}
+	for r := len(lines) - 1; r >= 0; r-- {
+		fmt.Fprint(w, paint(label_color, fmt.Sprintf("%2d ", r+1)))
+		for _, ch := range lines[r] {
+			switch ch {
+			case '+':
+				fmt.Fprint(w, " "+paint(white_color, "+"))
+			case '-':
+				fmt.Fprint(w, " "+paint(black_color, "-"))
+			default:
+				fmt.Fprintf(w, " %c", ch)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprint(w, "   ")
+	for c := range lines {
+		fmt.Fprint(w, paint(label_color, fmt.Sprintf(" %c", 'A'+c)))
+	}
+	fmt.Fprintln(w)
+}
+
+// Formats a clock like the browser does, as minutes:seconds.
+func formatClock(d time.Duration) string {
+	seconds := int(d / time.Second)
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// Returns the time used by both players, including the time that passed
+// since the game state was fetched.
+func (g *gameClient) clocks() [2]time.Duration {
+	clocks := g.time_used
+	if len(g.state.History) > 0 && !g.over() {
+		clocks[g.state.Next()] += time.Since(g.polled_at)
+	}
+	return clocks
+}
+
+// Like clocks, but returns the time left under the server's time control.
+func (g *gameClient) timeLeft() [2]time.Duration {
+	clocks := *g.time_left
+	if len(g.state.History) > 0 && !g.over() {
+		if clocks[g.state.Next()] -= time.Since(g.polled_at); clocks[g.state.Next()] < 0 {
+			clocks[g.state.Next()] = 0
+		}
+	}
+	return clocks
+}
+
+// Writes the board, clocks and most recent moves.
+func (g *gameClient) writeScreen(w io.Writer) {
+	fmt.Fprintln(w)
+	writeBoard(w, g.state.Fields, *color_arg)
+	clocks := g.clocks()
+	if g.time_left != nil {
+		clocks = g.timeLeft()
+	}
+	fmt.Fprintf(w, "\nWhite (+) %s   Black (-) %s\n",
+		formatClock(clocks[0]), formatClock(clocks[1]))
+	if g.draw_offer != 0 && !g.over() {
+		fmt.Fprintln(w, color_names[(1-g.draw_offer)/2], "offers a draw.")
+	}
+	var log bytes.Buffer
+	g.state.WriteLog(&log)
+	if lines := strings.SplitAfter(log.String(), "\n"); len(lines) > 1 {
+		lines = lines[:len(lines)-1]
+		if len(lines) > recent_log_lines {
+			fmt.Fprintln(w, "  ...")
+			lines = lines[len(lines)-recent_log_lines:]
+		}
+		fmt.Fprint(w, strings.Join(lines, ""))
+	}
+}
+
+const terminal_help = `Enter a move like A1-A2, or one of these commands:
+  board   show the board again
+  moves   show all moves played so far
+  draw    offer a draw, or accept the opponent's offer
+  resign  resign the game
+  abort   abort the game (only before both players moved)
+  help    show this help
+  quit    stop playing (the game stays open on the server)
+`
+
+// Reads moves from the terminal until it gets a valid one.  Returns false
+// if the user quits, ends the game, or standard input is closed.
+func (g *gameClient) readMove(input *bufio.Scanner) (ayu.Move, bool) {
+	for {
+		fmt.Print("Your move: ")
+		if !input.Scan() {
+			fmt.Println()
+			return ayu.Move{}, false
+		}
+		line := strings.ToUpper(strings.TrimSpace(input.Text()))
+		switch line {
+		case "":
+			continue
+		case "BOARD":
+			g.writeScreen(os.Stdout)
+		case "MOVES":
+			g.state.WriteLog(os.Stdout)
+		case "HELP":
+			fmt.Print(terminal_help)
+		case "QUIT":
+			return ayu.Move{}, false
+		case "DRAW", "RESIGN", "ABORT":
+			action := map[string]string{"DRAW": "offer_draw", "RESIGN": "resign", "ABORT": "abort"}[line]
+			if err := g.postAction(action); err != nil {
+				fmt.Println("Failed!", err)
+			} else if err := g.pollGame(0); err != nil {
+				fmt.Println("Could not fetch game state!", err)
+			} else if g.over() {
+				return ayu.Move{}, false
+			} else {
+				fmt.Println("Draw offered.")
+			}
+		default:
+			if move, ok := ayu.ParseMove(line); !ok {
+				fmt.Println("Could not parse move! Type 'help' for help.")
+			} else if !g.state.Valid(move) {
+				fmt.Println("Invalid move!")
+			} else {
+				return move, true
+			}
+		}
+	}
+}
+
+// Lets the user play the game in the terminal instead of a player program.
+func (g *gameClient) playInTerminal() error {
+	defer g.closeEvents()
+	if err := g.pollGame(0); err != nil {
+		fmt.Println("Could not fetch game state!", err)
+		return err
+	}
+	if g.white_key != nil {
+		fmt.Println("You are playing white (+).")
+	} else {
+		fmt.Println("You are playing black (-).")
+	}
+	fmt.Print(terminal_help)
+	input := bufio.NewScanner(os.Stdin)
+	for !g.over() {
+		g.writeScreen(os.Stdout)
+		if (g.state.Next() == 0) == (g.white_key != nil) {
+			// Player's turn
+			move, ok := g.readMove(input)
+			if !ok {
+				if g.over() {
+					break
+				}
+				return nil
+			}
+			g.state.Execute(move)
+			if err := g.postLastMove(); err != nil {
+				if g.endedMeanwhile() {
+					break
+				}
+				if g.taken_back > 0 {
+					fmt.Println(g.takenBackMessage() + ".")
+					continue
+				}
+				fmt.Printf("Failed to post move '%s': %s\n", move, err)
+				return err
+			}
+		} else {
+			// Opponent's turn
+			fmt.Println("Waiting for opponent...")
+			if err := g.pollGame(len(g.state.History) + 1); err != nil {
+				fmt.Println("Could not poll game state!", err)
+				return err
+			}
+			if g.taken_back > 0 {
+				fmt.Println(g.takenBackMessage() + ".")
+			}
+		}
+	}
+	g.writeScreen(os.Stdout)
+	result := g.gameResult()
+	if result.Winner == 0 {
+		fmt.Printf("Game over: %s. It's a draw.\n", result.Reason)
+	} else if (result.Winner > 0) == (g.white_key != nil) {
+		fmt.Printf("Game over: %s. You win!\n", result.Reason)
+	} else {
+		fmt.Printf("Game over: %s. You lose.\n", result.Reason)
+	}
+	return nil
+}
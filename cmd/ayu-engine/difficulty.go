@@ -0,0 +1,62 @@
+package main
+
+import "ayu"
+import "math"
+import "math/rand"
+
+// Settings that weaken the engine, for playing against people.
+type difficulty struct {
+	depth       int     // maximum search depth (0 for no limit)
+	nodes       int     // maximum nodes searched per move (0 for no limit)
+	temperature int     // see searchLimits
+	blunder     float64 // see searchLimits
+}
+
+// Named difficulty levels.  The names are the same as those accepted by the
+// server's /create handler.
+var difficulties = map[string]difficulty{
+	"beginner": {depth: 1, temperature: 30, blunder: 0.3},
+	"easy":     {depth: 2, temperature: 15, blunder: 0.15},
+	"medium":   {depth: 3, nodes: 20000, temperature: 5, blunder: 0.05},
+	"hard":     {nodes: 200000, temperature: 1},
+	"expert":   {},
+}
+
+// Names of the difficulty levels, from weakest to strongest.
+var difficulty_names = []string{"beginner", "easy", "medium", "hard", "expert"}
+
+// Picks one of the moves at random, with probability proportional to
+// exp((score - best) / temperature), so that moves close to the best are
+// played often and much worse ones hardly ever.  Without a temperature,
+// one of the best moves is picked.
+func pickMove(moves []ayu.Move, scores []int, temperature int) ayu.Move {
+	best := scores[0]
+	for _, score := range scores {
+		if score > best {
+			best = score
+		}
+	}
+	weights := make([]float64, len(moves))
+	total := 0.0
+	for i, score := range scores {
+		if temperature > 0 {
+			weights[i] = math.Exp(float64(score-best) / float64(temperature))
+		} else if score == best {
+			weights[i] = 1
+		}
+		total += weights[i]
+	}
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r -= w; r < 0 && w > 0 {
+			return moves[i]
+		}
+	}
+	// Rounding errors may leave a small remainder; play the best move.
+	for i, score := range scores {
+		if score == best {
+			return moves[i]
+		}
+	}
+	return moves[0]
+}
@@ -0,0 +1,152 @@
+package main
+
+import "ayu"
+import "math"
+
+// Scores assigned to won positions.  Wins found closer to the root of the
+// search score higher, so the engine prefers faster wins.
+const win_score = 1000000
+const max_ply = 1000
+
+// Features of a position, from the point of view of the player to move.
+// Since a player wins by joining all of their pieces into a single group,
+// fewer groups and smaller distances between them are better, so these are
+// the opponent's counts minus our own.
+type features struct {
+	Groups   float64
+	Distance float64
+}
+
+// Weights of the features in the evaluation, for one board size.  The
+// weighted sum of the features estimates the outcome: tanh of it is the
+// expected result for the player to move, from -1 (loss) to 1 (win).
+type weights struct {
+	Groups   float64 `json:"groups"`
+	Distance float64 `json:"distance"`
+}
+
+// Hand-tuned weights, used for board sizes without trained weights: a
+// single group is worth ten fields of distance.
+var default_weights = weights{Groups: 0.1, Distance: 0.01}
+
+// Evaluation scores are the weighted sum of the features times eval_scale,
+// rounded, so that the search can work with integers.
+const eval_scale = 100
+
+// Weights per board size, as loaded with --weights.  The weights.json file
+// next to this one has weights for every board size, trained from self-play
+// with the default settings: 1000 games up to size 11, and 500, 200, 150 and
+// 100 games for sizes 13 to 19, where games are slow.
+var size_weights = map[int]weights{}
+
+func weightsFor(size int) weights {
+	if w, ok := size_weights[size]; ok {
+		return w
+	}
+	return default_weights
+}
+
+func (w weights) score(f features) float64 {
+	return w.Groups*f.Groups + w.Distance*f.Distance
+}
+
+// Computes the features of the position.  Mobility would be a useful third
+// feature, but generating the moves of both players at every leaf slows
+// the search down too much.
+func computeFeatures(state *ayu.State) features {
+	player := state.NextPlayer()
+	my_groups, my_distance := groupStats(state.Fields, player)
+	his_groups, his_distance := groupStats(state.Fields, -player)
+	return features{
+		Groups:   float64(his_groups - my_groups),
+		Distance: float64(his_distance - my_distance)}
+}
+
+// Evaluates the position from the point of view of the player to move.
+func evaluate(state *ayu.State) int {
+	return int(math.Round(eval_scale * weightsFor(len(state.Fields)).score(computeFeatures(state))))
+}
+
+// Returns the number of groups of the given player, and the sum over all
+// groups of the distance to the nearest other group of that player, where
+// distance is measured through empty fields only.
+func groupStats(fields ayu.Fields, player int) (groups, distance int) {
+	size := len(fields)
+	label := make([][]int, size)
+	for r := range label {
+		label[r] = make([]int, size)
+	}
+	var groups_fields [][]ayu.Coords
+	for r := range fields {
+		for c := range fields[r] {
+			if fields[r][c] == player && label[r][c] == 0 {
+				groups++
+				group := []ayu.Coords{{r, c}}
+				label[r][c] = groups
+				for i := 0; i < len(group); i++ {
+					for _, d := range neighbours(group[i], size) {
+						if fields[d[0]][d[1]] == player && label[d[0]][d[1]] == 0 {
+							label[d[0]][d[1]] = groups
+							group = append(group, d)
+						}
+					}
+				}
+				groups_fields = append(groups_fields, group)
+			}
+		}
+	}
+	if groups < 2 {
+		return
+	}
+	for g, group := range groups_fields {
+		distance += distanceToOtherGroup(fields, label, group, g+1)
+	}
+	return
+}
+
+// Breadth-first search from the given group through empty fields, until a
+// field labelled with a different group is found.
+func distanceToOtherGroup(fields ayu.Fields, label [][]int, group []ayu.Coords, id int) int {
+	size := len(fields)
+	dist := make([][]int, size)
+	for r := range dist {
+		dist[r] = make([]int, size)
+	}
+	queue := append([]ayu.Coords{}, group...)
+	for _, c := range group {
+		dist[c[0]][c[1]] = 1
+	}
+	for i := 0; i < len(queue); i++ {
+		c := queue[i]
+		for _, d := range neighbours(c, size) {
+			if dist[d[0]][d[1]] != 0 {
+				continue
+			}
+			dist[d[0]][d[1]] = dist[c[0]][c[1]] + 1
+			if l := label[d[0]][d[1]]; l != 0 && l != id {
+				return dist[d[0]][d[1]] - 1
+			}
+			if fields[d[0]][d[1]] == 0 {
+				queue = append(queue, d)
+			}
+		}
+	}
+	return size * size // unreachable; can only happen on tiny boards
+}
+
+func neighbours(c ayu.Coords, size int) []ayu.Coords {
+	res := make([]ayu.Coords, 0, 4)
+	if c[0] > 0 {
+		res = append(res, ayu.Coords{c[0] - 1, c[1]})
+	}
+	if c[0]+1 < size {
+		res = append(res, ayu.Coords{c[0] + 1, c[1]})
+	}
+	if c[1] > 0 {
+		res = append(res, ayu.Coords{c[0], c[1] - 1})
+	}
+	if c[1]+1 < size {
+		res = append(res, ayu.Coords{c[0], c[1] + 1})
+	}
+	return res
+}
@@ -0,0 +1,488 @@
+// Reference player program for the client (see player/PROTOCOL.txt).
+//
+// Speaks both the legacy and the ayu protocol; which one is used is detected
+// from the first line received.  Diagnostics are written to standard error.
+package main
+
+import "ayu"
+import "bufio"
+import "flag"
+import "fmt"
+import "log"
+import "math/rand"
+import "os"
+import "strconv"
+import "strings"
+import "time"
+
+var strategy_arg = flag.String("strategy", "search", "Move selection strategy: "+strings.Join(strategyNames(), ", "))
+var difficulty_arg = flag.String("difficulty", "expert", "Difficulty level: "+strings.Join(difficulty_names, ", ")+" (the flags below override its settings)")
+var depth_arg = flag.Int("depth", 0, "Maximum search depth (0 for no limit)")
+var nodes_arg = flag.Int("nodes", 0, "Maximum number of nodes searched per move (0 for no limit)")
+var temperature_arg = flag.Int("temperature", 0, "Play moves scoring this much below the best 1/e times as often as the best (0 to always play the best)")
+var blunder_arg = flag.Float64("blunder", 0, "Probability of playing a random move")
+var move_time_arg = flag.Duration("move_time", time.Second, "Time to spend per move if not told by the client")
+var threads_arg = flag.Int("threads", 1, "Number of search threads")
+var hash_arg = flag.Int("hash", 16, "Size of the transposition table in megabytes (0 for none)")
+var seed_arg = flag.Int64("seed", 0, "Random seed (0 to seed from the clock)")
+var weights_arg = flag.String("weights", "", "JSON file with evaluation weights per board size, as written by --train")
+
+var train_arg = flag.Bool("train", false, "Train the evaluation weights for --train_size and write them to --weights, instead of playing")
+var train_size_arg = flag.Int("train_size", ayu.DefaultSize, "Board size to train for")
+var train_games_arg = flag.Int("train_games", 1000, "Number of self-play games to train on")
+var train_records_arg = flag.String("train_records", "", "Glob pattern of game records to train on instead of self-play")
+var lambda_arg = flag.Float64("lambda", 0.7, "Lambda of TD(lambda) training")
+var learning_rate_arg = flag.Float64("learning_rate", 0.002, "Learning rate of training")
+var explore_arg = flag.Float64("explore", 0.1, "Probability of a random move in self-play")
+
+const engine_name = "ayu-engine"
+const engine_author = "the ayu authors"
+
+var output = bufio.NewWriter(os.Stdout)
+
+func send(format string, args ...interface{}) {
+	fmt.Fprintf(output, format+"\n", args...)
+	output.Flush()
+}
+
+func readLines(lines chan<- string) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		lines <- strings.TrimSpace(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println("Error reading input:", err)
+	}
+	close(lines)
+}
+
+type engine struct {
+	strategy strategy
+	level    difficulty
+	threads  int
+	table    *transpositionTable // nil if disabled
+	hash_mb  int
+	state    *ayu.State
+}
+
+// Sets the size of the transposition table, which also clears it.
+func (e *engine) setHash(megabytes int) {
+	e.hash_mb = megabytes
+	e.table = nil
+	if megabytes > 0 {
+		e.table = newTranspositionTable(megabytes)
+	}
+}
+
+func (e *engine) setOption(name, value string) {
+	switch strings.ToLower(name) {
+	case "strategy":
+		if s, ok := strategies[value]; ok {
+			e.strategy = s
+		} else {
+			log.Println("Unknown strategy:", value)
+		}
+	case "difficulty":
+		if d, ok := difficulties[strings.ToLower(value)]; ok {
+			e.level = d
+		} else {
+			log.Println("Unknown difficulty:", value)
+		}
+	case "depth":
+		if d, err := strconv.Atoi(value); err == nil && d >= 0 {
+			e.level.depth = d
+		} else {
+			log.Println("Invalid depth:", value)
+		}
+	case "nodes":
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			e.level.nodes = n
+		} else {
+			log.Println("Invalid node limit:", value)
+		}
+	case "temperature":
+		if t, err := strconv.Atoi(value); err == nil && t >= 0 {
+			e.level.temperature = t
+		} else {
+			log.Println("Invalid temperature:", value)
+		}
+	case "blunder":
+		// In percent, since options are integers.
+		if p, err := strconv.Atoi(value); err == nil && p >= 0 && p <= 100 {
+			e.level.blunder = float64(p) / 100
+		} else {
+			log.Println("Invalid blunder probability:", value)
+		}
+	case "threads":
+		if n, err := strconv.Atoi(value); err == nil && n >= 1 {
+			e.threads = n
+		} else {
+			log.Println("Invalid number of threads:", value)
+		}
+	case "hash":
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			e.setHash(n)
+		} else {
+			log.Println("Invalid hash size:", value)
+		}
+	case "ponder":
+		// Only tells us that we may be asked to ponder.
+	default:
+		log.Println("Unknown option:", name)
+	}
+}
+
+// Plays the given moves on the engine's state.  Returns false if one of
+// them is invalid.
+func (e *engine) playMoves(moves []string) bool {
+	for _, move_str := range moves {
+		if move, ok := ayu.ParseMove(move_str); !ok || !e.state.Execute(move) {
+			log.Println("Invalid move:", move_str)
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the limits for a search that must end by the deadline, at the
+// engine's difficulty level.
+func (e *engine) limits(deadline time.Time) searchLimits {
+	return searchLimits{
+		deadline:    deadline,
+		depth:       e.level.depth,
+		nodes:       e.level.nodes,
+		temperature: e.level.temperature,
+		blunder:     e.level.blunder,
+		threads:     e.threads,
+		table:       e.table}
+}
+
+// The move selected by a search, and the reply we expect, if known.
+type searchResult struct {
+	move   ayu.Move
+	ponder *ayu.Move
+}
+
+func (r searchResult) String() string {
+	if r.ponder != nil {
+		return fmt.Sprintf("%s ponder %s", r.move, *r.ponder)
+	}
+	return r.move.String()
+}
+
+// Selects a move with the strategy and plays it in the given state.  Search
+// output is passed to info.  The expected reply is taken from the
+// transposition table.  This runs concurrently with the command loop, so
+// it must not access the engine.
+func think(select_move strategy, state *ayu.State, limits searchLimits, stop <-chan bool, info func(string)) searchResult {
+	var move ayu.Move
+	if limits.blunder > 0 && rand.Float64() < limits.blunder {
+		moves := state.Moves()
+		move = moves[rand.Intn(len(moves))]
+		info("string blunder " + move.String())
+	} else {
+		move = select_move(state, limits, stop, info)
+	}
+	state.Execute(move)
+	result := searchResult{move: move}
+	if limits.table != nil {
+		if d, ok := limits.table.probe(positionHash(state)); ok && d.has_move && state.Valid(d.move) {
+			result.ponder = &d.move
+		}
+	}
+	return result
+}
+
+func sendInfo(s string) {
+	send("info %s", s)
+}
+
+func logInfo(s string) {
+	log.Println(s)
+}
+
+// Legacy protocol: the board is the default size unless announced with
+// "Size", "Start" means we move first, and every move received must be
+// answered with our own.
+func (e *engine) runLegacy(first string, lines <-chan string) {
+	e.state = ayu.CreateState(ayu.DefaultSize)
+	for line, ok := first, true; ok; line, ok = <-lines {
+		switch {
+		case line == "Quit":
+			return
+		case strings.HasPrefix(line, "Size "):
+			if n, err := strconv.Atoi(line[5:]); err == nil && ayu.IsValidSize(n) {
+				e.state = ayu.CreateState(n)
+			} else {
+				log.Println("Invalid board size:", line[5:])
+			}
+			continue
+		case line == "Start":
+		case line == "":
+			continue
+		case !e.playMoves([]string{line}):
+			return
+		}
+		if e.state.Over() {
+			continue
+		}
+		limits := e.limits(time.Now().Add(*move_time_arg))
+		send("%s", think(e.strategy, e.state, limits, nil, logInfo).move)
+	}
+}
+
+// Parses the arguments of a position command into a new state.
+func parsePosition(args []string, size int) (*ayu.State, []string) {
+	if len(args) >= 1 && args[0] == "startpos" {
+		args = args[1:]
+		if !ayu.IsValidSize(size) {
+			return nil, nil
+		}
+		state := ayu.CreateState(size)
+		if len(args) > 0 && args[0] == "moves" {
+			args = args[1:]
+		}
+		return state, args
+	}
+	if len(args) >= 3 && args[0] == "board" {
+		rows := strings.Split(args[1], "/")
+		state := &ayu.State{Fields: make(ayu.Fields, len(rows)), History: ayu.History{}}
+		for r, row := range rows {
+			if len(row) != len(rows) {
+				return nil, nil
+			}
+			state.Fields[r] = make([]int, len(row))
+			for c, ch := range row {
+				if i := strings.IndexRune("-.+", ch); i < 0 {
+					return nil, nil
+				} else {
+					state.Fields[r][c] = i - 1
+				}
+			}
+		}
+		if args[2] == "b" {
+			// Pad the history so the right player is to move.  The padding
+			// is never looked at, since we don't undo past the root.
+			state.History = append(state.History, ayu.Move{})
+		}
+		args = args[3:]
+		if len(args) > 0 && args[0] == "moves" {
+			args = args[1:]
+		}
+		return state, args
+	}
+	return nil, nil
+}
+
+// Computes the search deadline from the arguments to a go command.
+func parseGo(args []string, next int) time.Time {
+	values := map[string]int{}
+	for i := 0; i+1 < len(args); i += 2 {
+		if v, err := strconv.Atoi(args[i+1]); err == nil {
+			values[args[i]] = v
+		}
+	}
+	move_time := *move_time_arg
+	if ms, ok := values["movetime"]; ok {
+		move_time = time.Duration(ms) * time.Millisecond
+	} else if left, ok := values[[]string{"wtime", "btime"}[next]]; ok {
+		inc := values[[]string{"winc", "binc"}[next]]
+		move_time = time.Duration(left/30+inc/2) * time.Millisecond
+		if max := time.Duration(left/2) * time.Millisecond; move_time > max {
+			move_time = max
+		}
+	}
+	return time.Now().Add(move_time)
+}
+
+// Ayu protocol (see player/PROTOCOL.txt).
+func (e *engine) runAyu(first string, lines <-chan string) {
+	size := ayu.DefaultSize
+	var stop chan bool
+	var done chan searchResult
+	// While pondering, the arguments of the go command, and the result if
+	// the search finished before ponderhit or stop.
+	pondering := false
+	var ponder_args []string
+	var ponder_result *searchResult
+
+	// Starts searching a copy of the current position.  Everything the
+	// search needs is passed to it, since options may change meanwhile.
+	search := func(limits searchLimits) {
+		stop = make(chan bool)
+		done = make(chan searchResult, 1)
+		go func(select_move strategy, state *ayu.State, stop <-chan bool, done chan<- searchResult) {
+			done <- think(select_move, state, limits, stop, sendInfo)
+		}(e.strategy, e.state.Clone(), stop, done)
+	}
+
+	for line, ok := first, true; ok; {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			fields = []string{""}
+		}
+		switch fields[0] {
+		case "ayu":
+			send("id name %s", engine_name)
+			send("id author %s", engine_author)
+			send("option name Strategy type string default search")
+			send("option name Difficulty type string default %s", *difficulty_arg)
+			send("option name Depth type spin default %d min 0 max 100", e.level.depth)
+			send("option name Nodes type spin default %d min 0 max 1000000000", e.level.nodes)
+			send("option name Temperature type spin default %d min 0 max 1000", e.level.temperature)
+			send("option name Blunder type spin default %d min 0 max 100", int(e.level.blunder*100))
+			send("option name Threads type spin default %d min 1 max 256", e.threads)
+			send("option name Hash type spin default %d min 0 max 65536", e.hash_mb)
+			send("option name Ponder type check default false")
+			send("ayuok 1")
+		case "setoption":
+			// setoption name <name> value <value>
+			if len(fields) >= 5 && fields[1] == "name" && fields[3] == "value" {
+				e.setOption(fields[2], strings.Join(fields[4:], " "))
+			}
+		case "isready":
+			send("readyok")
+		case "newgame":
+			if len(fields) >= 3 && fields[1] == "size" {
+				if n, err := strconv.Atoi(fields[2]); err == nil && ayu.IsValidSize(n) {
+					size = n
+				} else {
+					log.Println("Invalid board size:", fields[2])
+				}
+			}
+			e.state = ayu.CreateState(size)
+			e.setHash(e.hash_mb)
+		case "position":
+			if state, moves := parsePosition(fields[1:], size); state == nil {
+				log.Println("Invalid position:", line)
+			} else {
+				e.state = state
+				e.playMoves(moves)
+			}
+		case "go":
+			if done != nil || pondering || e.state == nil || e.state.Over() {
+				log.Println("Nothing to search!")
+				break
+			}
+			if len(fields) >= 2 && fields[1] == "ponder" {
+				// Search until told whether the opponent played the
+				// expected move; the clock only starts with ponderhit.
+				pondering = true
+				ponder_args = fields[2:]
+				search(e.limits(time.Time{}))
+			} else {
+				search(e.limits(parseGo(fields[1:], e.state.Next())))
+			}
+		case "ponderhit":
+			if !pondering {
+				log.Println("Not pondering!")
+				break
+			}
+			pondering = false
+			log.Println("Ponder hit")
+			if ponder_result != nil {
+				send("bestmove %s", *ponder_result)
+				ponder_result = nil
+				break
+			}
+			// Restart with the time limits; the transposition table keeps
+			// what the search found so far.
+			close(stop)
+			<-done
+			search(e.limits(parseGo(ponder_args, e.state.Next())))
+		case "stop":
+			if pondering {
+				pondering = false
+				if ponder_result != nil {
+					send("bestmove %s", *ponder_result)
+					ponder_result = nil
+					break
+				}
+			}
+			if stop != nil {
+				close(stop)
+				stop = nil
+			}
+		case "quit":
+			return
+		}
+
+		// Wait for the next command, or for the search to finish.
+		for {
+			select {
+			case line, ok = <-lines:
+			case result := <-done:
+				if pondering {
+					ponder_result = &result
+				} else {
+					send("bestmove %s", result)
+				}
+				done = nil
+				stop = nil
+				continue
+			}
+			break
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+	log.SetPrefix(engine_name + ": ")
+	if *seed_arg != 0 {
+		rand.Seed(*seed_arg)
+	}
+	if *train_arg {
+		if *weights_arg == "" {
+			log.Fatalln("Training needs a --weights file to write.")
+		}
+		train()
+		return
+	}
+	if *weights_arg != "" {
+		if w, err := loadWeights(*weights_arg); err != nil {
+			log.Fatalln("Could not read weights:", err)
+		} else {
+			size_weights = w
+		}
+	}
+	e := engine{threads: *threads_arg}
+	e.setHash(*hash_arg)
+	if d, ok := difficulties[*difficulty_arg]; !ok {
+		log.Fatalln("Unknown difficulty:", *difficulty_arg)
+	} else {
+		e.level = d
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "depth":
+			e.level.depth = *depth_arg
+		case "nodes":
+			e.level.nodes = *nodes_arg
+		case "temperature":
+			e.level.temperature = *temperature_arg
+		case "blunder":
+			e.level.blunder = *blunder_arg
+		}
+	})
+	if s, ok := strategies[*strategy_arg]; !ok {
+		log.Fatalln("Unknown strategy:", *strategy_arg)
+	} else {
+		e.strategy = s
+	}
+	lines := make(chan string)
+	go readLines(lines)
+	for line := range lines {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "ayu") {
+			log.Println("Using ayu protocol")
+			e.runAyu(line, lines)
+		} else {
+			log.Println("Using legacy protocol")
+			e.runLegacy(line, lines)
+		}
+		break
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import "ayu"
+import "fmt"
+import "log"
+import "math/rand"
+import "sort"
+import "sync"
+import "sync/atomic"
+import "time"
+
+// Limits on the search for a single move.  A zero value means no limit.
+type searchLimits struct {
+	deadline time.Time
+	depth    int
+	nodes    int
+
+	// Deliberate weaknesses, for lower difficulty levels
+	temperature int     // moves scoring this much below the best are played 1/e as often
+	blunder     float64 // probability of playing a random move instead
+
+	// Resources
+	threads int                 // number of search threads; 0 or 1 for one
+	table   *transpositionTable // shared between searches, or nil for none
+}
+
+// A strategy selects a move for the player to move in state, which must
+// have at least one valid move.  It must return promptly once stop is
+// closed.  Search output is reported through info.
+type strategy func(state *ayu.State, limits searchLimits, stop <-chan bool, info func(string)) ayu.Move
+
+var strategies = map[string]strategy{
+	"random": selectRandom,
+	"greedy": selectGreedy,
+	"search": selectSearch,
+}
+
+func strategyNames() (names []string) {
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Plays a uniformly random valid move.
+func selectRandom(state *ayu.State, limits searchLimits, stop <-chan bool, info func(string)) ayu.Move {
+	moves := state.Moves()
+	return moves[rand.Intn(len(moves))]
+}
+
+// Plays the move with the best evaluation after playing it, breaking ties
+// randomly.
+func selectGreedy(state *ayu.State, limits searchLimits, stop <-chan bool, info func(string)) ayu.Move {
+	moves := state.Moves()
+	scores := make([]int, len(moves))
+	best_score := -win_score
+	for i, move := range moves {
+		state.Execute(move)
+		scores[i] = -evaluate(state)
+		if state.Over() {
+			scores[i] = -win_score
+		}
+		state.Undo()
+		if scores[i] > best_score {
+			best_score = scores[i]
+		}
+	}
+	info(fmt.Sprintf("depth 1 nodes %d score %d", len(moves), best_score))
+	return pickMove(moves, scores, limits.temperature)
+}
+
+type searcher struct {
+	state     *ayu.State
+	deadline  time.Time
+	max_nodes int
+	stop      <-chan bool
+	table     *transpositionTable // may be nil
+	nodes     int
+	total     *int64 // nodes searched by all threads
+	aborted   bool
+}
+
+// Checks whether the search should be aborted.
+func (s *searcher) abort() bool {
+	if !s.aborted {
+		select {
+		case <-s.stop:
+			s.aborted = true
+		default:
+			s.aborted = !s.deadline.IsZero() && time.Now().After(s.deadline) ||
+				s.max_nodes > 0 && s.nodes >= s.max_nodes
+		}
+	}
+	return s.aborted
+}
+
+// Negamax search with alpha-beta pruning.  Returns the score of the
+// position from the point of view of the player to move.
+func (s *searcher) negamax(depth, ply, alpha, beta int) int {
+	s.nodes++
+	atomic.AddInt64(s.total, 1)
+	if depth == 0 || s.abort() {
+		if s.state.Over() {
+			return win_score - ply
+		}
+		return evaluate(s.state)
+	}
+	var hash uint64
+	var entry ttData
+	if s.table != nil {
+		hash = positionHash(s.state)
+		if d, ok := s.table.probe(hash); ok {
+			entry = d
+			if score := scoreFromTable(d.score, ply); d.depth >= depth {
+				switch {
+				case d.bound == bound_exact,
+					d.bound == bound_lower && score >= beta,
+					d.bound == bound_upper && score <= alpha:
+					return clamp(score, alpha, beta)
+				}
+			}
+		}
+	}
+	moves := s.state.Moves()
+	if len(moves) == 0 {
+		// The player to move can't move, which means they won.
+		return win_score - ply
+	}
+	if entry.has_move {
+		// Search the best move found before first.
+		for i, move := range moves {
+			if move == entry.move {
+				moves[0], moves[i] = moves[i], moves[0]
+				break
+			}
+		}
+	}
+	alpha_orig := alpha
+	best_move := moves[0]
+	for _, move := range moves {
+		s.state.Execute(move)
+		score := -s.negamax(depth-1, ply+1, -beta, -alpha)
+		s.state.Undo()
+		if s.aborted {
+			break
+		}
+		if score > alpha {
+			alpha = score
+			best_move = move
+			if alpha >= beta {
+				break
+			}
+		}
+	}
+	if s.table != nil && !s.aborted && depth <= 0xff {
+		d := ttData{score: scoreToTable(alpha, ply), depth: depth, bound: bound_upper}
+		if alpha >= beta {
+			d.bound = bound_lower
+		} else if alpha > alpha_orig {
+			d.bound = bound_exact
+		}
+		if alpha > alpha_orig {
+			d.move, d.has_move = best_move, true
+		}
+		s.table.store(hash, d)
+	}
+	return alpha
+}
+
+func clamp(score, alpha, beta int) int {
+	if score < alpha {
+		return alpha
+	}
+	if score > beta {
+		return beta
+	}
+	return score
+}
+
+// Searches the moves at the root with increasing depth, starting at the
+// given one, until the search is aborted or limits.depth is reached.  The
+// best move of each completed iteration is searched first in the next one.
+// With a temperature, every move is searched with a full window, so that
+// moves can be picked by their exact scores.  Report, if not nil, is called
+// after each completed iteration.  Returns the best move and, with a
+// temperature, the scores of the moves of the last completed iteration.
+func (s *searcher) iterate(moves []ayu.Move, first_depth int, limits searchLimits,
+	report func(depth, score int, best ayu.Move)) (ayu.Move, []int) {
+	best_move := moves[0]
+	var scores []int
+	for depth := first_depth; limits.depth == 0 || depth <= limits.depth; depth++ {
+		alpha := -win_score - max_ply
+		best_index := 0
+		iteration_scores := make([]int, len(moves))
+		for i, move := range moves {
+			bound := alpha
+			if limits.temperature > 0 {
+				bound = -win_score - max_ply
+			}
+			s.state.Execute(move)
+			score := -s.negamax(depth-1, 1, -win_score-max_ply, -bound)
+			s.state.Undo()
+			if s.aborted {
+				break
+			}
+			iteration_scores[i] = score
+			if score > alpha {
+				alpha = score
+				best_index = i
+			}
+		}
+		if s.aborted {
+			// Results of an incomplete iteration can't be trusted, except
+			// that the first move has been fully searched.
+			if best_index > 0 {
+				best_move = moves[best_index]
+			}
+			break
+		}
+		moves[0], moves[best_index] = moves[best_index], moves[0]
+		iteration_scores[0], iteration_scores[best_index] = iteration_scores[best_index], iteration_scores[0]
+		best_move = moves[0]
+		if limits.temperature > 0 {
+			scores = iteration_scores
+		}
+		if report != nil {
+			report(depth, alpha, best_move)
+		}
+		if alpha >= win_score-max_ply || alpha <= -(win_score-max_ply) || len(moves) == 1 {
+			break // the result is decided; no point in searching deeper
+		}
+	}
+	return best_move, scores
+}
+
+// Iterative deepening alpha-beta search.  With more than one thread, the
+// other threads search the same position (lazy SMP): they start at
+// alternating depths and with the moves in a different order, and share
+// their results through the transposition table.  Only the main thread's
+// result is used.
+func selectSearch(state *ayu.State, limits searchLimits, stop <-chan bool, info func(string)) ayu.Move {
+	var total int64
+	start := time.Now()
+	moves := state.Moves()
+	rand.Shuffle(len(moves), func(i, j int) { moves[i], moves[j] = moves[j], moves[i] })
+
+	helpers_stop := make(chan bool)
+	var helpers sync.WaitGroup
+	for i := 1; i < limits.threads && limits.table != nil; i++ {
+		helper_moves := append([]ayu.Move{}, moves...)
+		rand.Shuffle(len(helper_moves), func(i, j int) {
+			helper_moves[i], helper_moves[j] = helper_moves[j], helper_moves[i]
+		})
+		h := &searcher{state: state.Clone(), deadline: limits.deadline, stop: helpers_stop, table: limits.table, total: &total}
+		helper_limits := limits
+		helper_limits.temperature = 0
+		helpers.Add(1)
+		go func(first_depth int) {
+			defer helpers.Done()
+			h.iterate(helper_moves, first_depth, helper_limits, nil)
+		}(1 + i%2)
+	}
+
+	s := searcher{state: state.Clone(), deadline: limits.deadline, max_nodes: limits.nodes, stop: stop, table: limits.table, total: &total}
+	best_move, scores := s.iterate(moves, 1, limits, func(depth, score int, best ayu.Move) {
+		elapsed := time.Since(start)
+		nodes := atomic.LoadInt64(&total)
+		line := fmt.Sprintf("depth %d nodes %d nps %d time %d",
+			depth, nodes, int64(float64(nodes)/elapsed.Seconds()), elapsed.Nanoseconds()/1e6)
+		if limits.table != nil {
+			line += fmt.Sprintf(" hashfull %d", limits.table.hashFull())
+		}
+		info(line + fmt.Sprintf(" score %d pv %s", score, best))
+	})
+	close(helpers_stop)
+	helpers.Wait()
+
+	stats := fmt.Sprintf("Searched %d nodes in %s", total, time.Since(start))
+	if limits.table != nil {
+		stats += fmt.Sprintf(", hash table %d%% full", limits.table.hashFull()/10)
+	}
+	log.Print(stats)
+	if scores != nil {
+		return pickMove(moves, scores, limits.temperature)
+	}
+	return best_move
+}
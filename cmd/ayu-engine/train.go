@@ -0,0 +1,244 @@
+package main
+
+import "ayu"
+import "bufio"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io/ioutil"
+import "log"
+import "math"
+import "math/rand"
+import "os"
+import "path/filepath"
+import "strconv"
+import "strings"
+
+// Self-play games are abandoned after this many moves.
+const train_max_moves = 500
+
+// Greedy self-play moves are picked from a random sample of at most this
+// many moves, which keeps training on large boards fast enough.
+const train_max_candidates = 32
+
+// Reads weights per board size from a JSON file like {"11": {"groups":
+// 0.1, "distance": 0.01}}.
+func loadWeights(filename string) (map[int]weights, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var by_name map[string]weights
+	if err := json.Unmarshal(data, &by_name); err != nil {
+		return nil, err
+	}
+	res := map[int]weights{}
+	for name, w := range by_name {
+		if size, err := strconv.Atoi(name); err != nil || !ayu.IsValidSize(size) {
+			return nil, fmt.Errorf("Invalid board size in weights file: %q", name)
+		} else {
+			res[size] = w
+		}
+	}
+	return res, nil
+}
+
+// Writes weights per board size to a JSON file, in the format read by
+// loadWeights.
+func saveWeights(filename string, all map[int]weights) error {
+	by_name := map[string]weights{}
+	for size, w := range all {
+		by_name[strconv.Itoa(size)] = w
+	}
+	data, err := json.MarshalIndent(by_name, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// Tunes weights with TD(lambda): after each game, the weights are moved
+// towards the lambda-return of every position, where the return of the
+// final position is a win for the player to move.
+type trainer struct {
+	w             weights
+	lambda        float64
+	learning_rate float64
+}
+
+func (t *trainer) value(f features) float64 {
+	return math.Tanh(t.w.score(f))
+}
+
+// Updates the weights from the positions of one game, given as the
+// features of each position from the point of view of the player to move.
+// If the game finished, the player to move after the last position won;
+// otherwise the value of the last position stands in for the outcome.
+func (t *trainer) learn(positions []features, finished bool) {
+	var ret float64
+	for i := len(positions) - 1; i >= 0; i-- {
+		f := positions[i]
+		v := t.value(f)
+		// The return of this position is the negated return of the next,
+		// seen by the other player.  Returns are computed with the weights
+		// before this game's updates, as in offline TD(lambda).
+		switch {
+		case i < len(positions)-1:
+			ret = -((1-t.lambda)*t.value(positions[i+1]) + t.lambda*ret)
+		case finished:
+			ret = -1
+		default:
+			ret = v
+		}
+		// Normalized gradient step, so that the step size doesn't depend
+		// on the magnitude of the features.
+		norm := 1 + f.Groups*f.Groups + f.Distance*f.Distance
+		step := t.learning_rate * (ret - v) * (1 - v*v) / norm
+		t.w.Groups += step * f.Groups
+		t.w.Distance += step * f.Distance
+	}
+}
+
+// Plays a game against itself with the current weights: greedily (see
+// train_max_candidates) with probability 1-explore, otherwise at random.
+// Returns the features of each position before a move, and whether the
+// game finished.
+func (t *trainer) selfPlay(size int, explore float64) ([]features, bool) {
+	state := ayu.CreateState(size)
+	var positions []features
+	for len(state.History) < train_max_moves {
+		moves := state.Moves()
+		if len(moves) == 0 {
+			// The player to move can't move, which means they won.
+			return positions, true
+		}
+		positions = append(positions, computeFeatures(state))
+		move := moves[rand.Intn(len(moves))]
+		if rand.Float64() >= explore {
+			if len(moves) > train_max_candidates {
+				rand.Shuffle(len(moves), func(i, j int) { moves[i], moves[j] = moves[j], moves[i] })
+				moves = moves[:train_max_candidates]
+			}
+			best := math.Inf(-1)
+			for _, m := range moves {
+				state.Execute(m)
+				score := -t.w.score(computeFeatures(state))
+				if state.Over() {
+					score = math.Inf(-1)
+				}
+				state.Undo()
+				if score > best {
+					best, move = score, m
+				}
+			}
+		}
+		state.Execute(move)
+	}
+	return positions, false
+}
+
+// Reads the moves of a game record, as written by the observer or by
+// ayu-tournament, and returns the final state.
+func readRecord(filename string) (*ayu.State, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	size := ayu.DefaultSize
+	var state *ayu.State
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[Size ") {
+			if n, err := strconv.Atoi(strings.Trim(line[6:], "\"]")); err == nil && ayu.IsValidSize(n) {
+				size = n
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "[") {
+			continue
+		}
+		if state == nil {
+			state = ayu.CreateState(size)
+		}
+		// Lines look like "  1. D9-E9    E1-E2".
+		for _, word := range strings.Fields(line)[1:] {
+			if move, ok := ayu.ParseMove(word); !ok || !state.Execute(move) {
+				return nil, fmt.Errorf("Invalid move in %s: %s", filename, word)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, errors.New("No moves in " + filename)
+	}
+	return state, nil
+}
+
+// Returns the features of all positions of an archived game, and whether
+// it finished on the board rather than e.g. by resignation.
+func recordPositions(state *ayu.State) ([]features, bool) {
+	replay := ayu.CreateState(len(state.Fields))
+	var positions []features
+	for _, move := range state.History {
+		positions = append(positions, computeFeatures(replay))
+		replay.Execute(move)
+	}
+	return positions, replay.Over()
+}
+
+// Trains the weights for one board size, from the archived games matching
+// --train_records if given, and otherwise from self-play, and writes them
+// to the --weights file, keeping the weights of other sizes.
+func train() {
+	size := *train_size_arg
+	if !ayu.IsValidSize(size) {
+		log.Fatalln("Invalid board size:", size)
+	}
+	all := map[int]weights{}
+	if loaded, err := loadWeights(*weights_arg); err == nil {
+		all = loaded
+	} else if !os.IsNotExist(err) {
+		log.Fatalln("Could not read weights:", err)
+	}
+	t := trainer{w: weightsFor(size), lambda: *lambda_arg, learning_rate: *learning_rate_arg}
+	if w, ok := all[size]; ok {
+		t.w = w
+	}
+	if *train_records_arg != "" {
+		filenames, err := filepath.Glob(*train_records_arg)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		used := 0
+		for _, filename := range filenames {
+			if state, err := readRecord(filename); err != nil {
+				log.Println(err)
+			} else if len(state.Fields) == size {
+				t.learn(recordPositions(state))
+				used++
+			}
+		}
+		log.Printf("Trained on %d of %d records", used, len(filenames))
+	} else {
+		finished := 0
+		for i := 1; i <= *train_games_arg; i++ {
+			positions, ok := t.selfPlay(size, *explore_arg)
+			t.learn(positions, ok)
+			if ok {
+				finished++
+			}
+			if i%10 == 0 {
+				log.Printf("%d games (%d finished): %+v", i, finished, t.w)
+			}
+		}
+	}
+	all[size] = t.w
+	if err := saveWeights(*weights_arg, all); err != nil {
+		log.Fatalln("Could not write weights:", err)
+	}
+	log.Printf("Weights for size %d written to %s: %+v", size, *weights_arg, t.w)
+}
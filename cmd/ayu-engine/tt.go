@@ -0,0 +1,172 @@
+package main
+
+import "ayu"
+import "math/rand"
+import "sync/atomic"
+
+// Kinds of scores stored in the transposition table.
+const (
+	bound_exact = iota + 1
+	bound_lower // the score is at least the stored one (fail high)
+	bound_upper // the score is at most the stored one (fail low)
+)
+
+// Largest board size, for the Zobrist keys.
+const max_size = 19
+
+// Random keys of the Zobrist hash: one per field and colour, and one for
+// black to move.  They are generated from a fixed seed, so hashes don't
+// depend on --seed.
+var zobrist_fields [max_size * max_size][2]uint64
+var zobrist_black uint64
+
+func init() {
+	r := rand.New(rand.NewSource(0x41797521))
+	for i := range zobrist_fields {
+		zobrist_fields[i][0] = r.Uint64()
+		zobrist_fields[i][1] = r.Uint64()
+	}
+	zobrist_black = r.Uint64()
+}
+
+// Returns the Zobrist hash of the position.
+func positionHash(state *ayu.State) uint64 {
+	var h uint64
+	for r, row := range state.Fields {
+		for c, v := range row {
+			if v != 0 {
+				h ^= zobrist_fields[r*max_size+c][(v+1)/2]
+			}
+		}
+	}
+	if state.Next() != 0 {
+		h ^= zobrist_black
+	}
+	return h
+}
+
+// A transposition table shared by all search threads.  Entries are
+// accessed without locks: each is stored as its data and its data xor the
+// position hash, so an entry torn by concurrent writes fails verification
+// and is ignored, like any other hash collision.
+type transpositionTable struct {
+	entries []ttEntry
+}
+
+type ttEntry struct {
+	check uint64 // hash ^ data
+	data  uint64
+}
+
+// Bytes per table entry, for sizing the table.
+const tt_entry_size = 16
+
+// Creates a table using at most the given number of megabytes.
+func newTranspositionTable(megabytes int) *transpositionTable {
+	n := megabytes << 20 / tt_entry_size
+	if n < 1 {
+		n = 1
+	}
+	return &transpositionTable{entries: make([]ttEntry, n)}
+}
+
+// Data of an entry, packed into 64 bits as (from the lowest bit) the
+// score offset by 2^31, the depth (8 bits), the bound (2 bits) and the
+// best move (20 bits, 5 per coordinate, plus a bit for its presence).
+type ttData struct {
+	score    int
+	depth    int
+	bound    int
+	move     ayu.Move
+	has_move bool
+}
+
+func (d ttData) pack() uint64 {
+	v := uint64(int64(d.score)+1<<31) & 0xffffffff
+	v |= uint64(d.depth&0xff) << 32
+	v |= uint64(d.bound&3) << 40
+	if d.has_move {
+		v |= 1 << 42
+		shift := uint(43)
+		for _, c := range d.move {
+			for _, x := range c {
+				v |= uint64(x&31) << shift
+				shift += 5
+			}
+		}
+	}
+	return v
+}
+
+func unpack(v uint64) (d ttData) {
+	d.score = int(int64(v&0xffffffff) - 1<<31)
+	d.depth = int(v >> 32 & 0xff)
+	d.bound = int(v >> 40 & 3)
+	if d.has_move = v>>42&1 != 0; d.has_move {
+		shift := uint(43)
+		for i := range d.move {
+			for j := range d.move[i] {
+				d.move[i][j] = int(v >> shift & 31)
+				shift += 5
+			}
+		}
+	}
+	return
+}
+
+// Returns the entry for the position with the given hash, if any.
+func (t *transpositionTable) probe(hash uint64) (ttData, bool) {
+	e := &t.entries[hash%uint64(len(t.entries))]
+	data := atomic.LoadUint64(&e.data)
+	if data == 0 || atomic.LoadUint64(&e.check)^data != hash {
+		return ttData{}, false
+	}
+	return unpack(data), true
+}
+
+// Stores an entry for the position with the given hash, replacing any
+// entry in its slot.
+func (t *transpositionTable) store(hash uint64, d ttData) {
+	e := &t.entries[hash%uint64(len(t.entries))]
+	data := d.pack()
+	atomic.StoreUint64(&e.data, data)
+	atomic.StoreUint64(&e.check, hash^data)
+}
+
+// Returns how full the table is, in permille, by sampling its first
+// thousand entries.
+func (t *transpositionTable) hashFull() int {
+	n := len(t.entries)
+	if n > 1000 {
+		n = 1000
+	}
+	used := 0
+	for i := 0; i < n; i++ {
+		if atomic.LoadUint64(&t.entries[i].data) != 0 {
+			used++
+		}
+	}
+	return used * 1000 / n
+}
+
+// Scores of won positions depend on the distance from the root, so they
+// are stored relative to the position instead.
+func scoreToTable(score, ply int) int {
+	switch {
+	case score >= win_score-max_ply:
+		return score + ply
+	case score <= -(win_score - max_ply):
+		return score - ply
+	}
+	return score
+}
+
+func scoreFromTable(score, ply int) int {
+	switch {
+	case score >= win_score-max_ply:
+		return score - ply
+	case score <= -(win_score - max_ply):
+		return score + ply
+	}
+	return score
+}
@@ -0,0 +1,127 @@
+// Plays a match between two player programs locally, without a server.
+//
+// Games are played with alternating colours and adjudicated with ayu.State.
+// After each game the running score and Elo difference (from the point of
+// view of the first player) are printed.  With --sprt, the match stops as
+// soon as the sequential probability ratio test reaches a decision.
+package main
+
+import "ayu"
+import "ayu/player"
+import "flag"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "log"
+import "math"
+import "os"
+import "time"
+
+var first_arg = flag.String("first", "", "Command to run the first player program")
+var second_arg = flag.String("second", "", "Command to run the second player program")
+var first_protocol_arg = flag.String("first_protocol", "ayu", "Protocol spoken by the first player: "+player.ProtocolNames)
+var second_protocol_arg = flag.String("second_protocol", "ayu", "Protocol spoken by the second player: "+player.ProtocolNames)
+var games_arg = flag.Int("games", 100, "Maximum number of games to play")
+var size_arg = flag.Int("size", ayu.DefaultSize, "Board size")
+var time_arg = flag.Duration("time", 0, "Initial time per player per game (0 for no limit)")
+var increment_arg = flag.Duration("increment", 0, "Time added to a player's clock after each move")
+var max_moves_arg = flag.Int("max_moves", 1000, "Declare a draw after this many moves (0 for no limit)")
+var sprt_arg = flag.String("sprt", "", "Stop early when the SPRT accepts elo0 or elo1, given as elo0,elo1")
+var alpha_arg = flag.Float64("alpha", 0.05, "SPRT false positive rate")
+var beta_arg = flag.Float64("beta", 0.05, "SPRT false negative rate")
+var quiet_arg = flag.Bool("quiet", false, "Don't copy player errors to standard error")
+
+// Plays a single game between freshly started players.  The first player
+// plays white if first_white is true.
+func playGame(first_white bool) (player.Result, error) {
+	commands := [2]string{*first_arg, *second_arg}
+	protocols := [2]string{*first_protocol_arg, *second_protocol_arg}
+	if !first_white {
+		commands[0], commands[1] = commands[1], commands[0]
+		protocols[0], protocols[1] = protocols[1], protocols[0]
+	}
+	var stderr io.Writer = os.Stderr
+	if *quiet_arg {
+		stderr = ioutil.Discard
+	}
+	var procs [2]*player.Process
+	for i := range procs {
+		if p, err := player.Start(commands[i], protocols[i], nil, stderr); err != nil {
+			// Stop the player started before, and wait for it to exit.
+			for _, started := range procs[:i] {
+				if err := started.Close(time.Second); err != nil {
+					log.Println(err)
+				}
+			}
+			return player.Result{}, err
+		} else {
+			procs[i] = p
+		}
+	}
+	tc := player.TimeControl{Initial: *time_arg, Increment: *increment_arg}
+	result := player.Play(procs[0], procs[1], *size_arg, tc, *max_moves_arg)
+	for _, p := range procs {
+		if err := p.Close(time.Second); err != nil {
+			log.Println(err)
+		}
+	}
+	return result, nil
+}
+
+func formatElo(s score) string {
+	elo, margin := s.elo()
+	if math.IsInf(elo, 0) || math.IsNaN(elo) {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f +/- %.1f", elo, margin)
+}
+
+func main() {
+	flag.Parse()
+	if *first_arg == "" || *second_arg == "" {
+		log.Fatalln("Need both --first and --second player commands.")
+	}
+	if !ayu.IsValidSize(*size_arg) {
+		log.Fatalln("Invalid board size:", *size_arg)
+	}
+	var test *sprt
+	if *sprt_arg != "" {
+		test = &sprt{alpha: *alpha_arg, beta: *beta_arg}
+		if _, err := fmt.Sscanf(*sprt_arg, "%g,%g", &test.elo0, &test.elo1); err != nil {
+			log.Fatalln("Could not parse --sprt:", err)
+		}
+	}
+	var s score
+	for i := 0; i < *games_arg; i++ {
+		first_white := i%2 == 0
+		result, err := playGame(first_white)
+		if err != nil {
+			log.Fatalln("Could not play game:", err)
+		}
+		switch {
+		case result.Winner < 0:
+			s.Draws++
+		case (result.Winner == 0) == first_white:
+			s.Wins++
+		default:
+			s.Losses++
+		}
+		colors := "first-second"
+		if !first_white {
+			colors = "second-first"
+		}
+		fmt.Printf("Game %d (%s): %s after %d moves. Score: +%d -%d =%d. Elo: %s\n",
+			i+1, colors, result, len(result.State.History),
+			s.Wins, s.Losses, s.Draws, formatElo(s))
+		if test != nil {
+			if decision := test.decide(s); decision != 0 {
+				lower, upper := test.bounds()
+				fmt.Printf("SPRT: LLR %.2f (%.2f, %.2f); accepted elo%d.\n",
+					test.llr(s), lower, upper, (decision+1)/2)
+				break
+			}
+		}
+	}
+	fmt.Printf("Final score: +%d -%d =%d (%.1f%%). Elo: %s\n",
+		s.Wins, s.Losses, s.Draws, 100*s.fraction(), formatElo(s))
+}
@@ -0,0 +1,81 @@
+package main
+
+import "math"
+
+// Wins, losses and draws, from the point of view of the first player.
+type score struct {
+	Wins, Losses, Draws int
+}
+
+func (s score) games() int {
+	return s.Wins + s.Losses + s.Draws
+}
+
+// Average points per game, counting a draw as half a point.
+func (s score) fraction() float64 {
+	return (float64(s.Wins) + float64(s.Draws)/2) / float64(s.games())
+}
+
+// Converts an expected score (between 0 and 1) to an Elo difference.
+func eloFromFraction(f float64) float64 {
+	return -400 * math.Log10(1/f-1)
+}
+
+// Converts an Elo difference to an expected score.
+func fractionFromElo(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// Returns the estimated Elo difference and the margin of the 95%
+// confidence interval around it.  The margin is infinite if the score is
+// too lopsided to estimate it.
+func (s score) elo() (elo, margin float64) {
+	n := float64(s.games())
+	f := s.fraction()
+	// Standard deviation of the score of a single game.
+	w, l, d := float64(s.Wins)/n, float64(s.Losses)/n, float64(s.Draws)/n
+	dev := math.Sqrt(w*math.Pow(1-f, 2) + l*math.Pow(0-f, 2) + d*math.Pow(0.5-f, 2))
+	// 1.96 standard deviations of the mean on either side.
+	delta := 1.96 * dev / math.Sqrt(n)
+	elo = eloFromFraction(f)
+	if f-delta <= 0 || f+delta >= 1 {
+		return elo, math.Inf(1)
+	}
+	return elo, (eloFromFraction(f+delta) - eloFromFraction(f-delta)) / 2
+}
+
+// Sequential probability ratio test of the hypothesis that the Elo
+// difference is elo1 against the hypothesis that it is elo0.
+type sprt struct {
+	elo0, elo1  float64
+	alpha, beta float64 // false positive and false negative rates
+}
+
+// Returns the log-likelihood ratio of the score, treating a draw as half a
+// win and half a loss.
+func (t sprt) llr(s score) float64 {
+	p0, p1 := fractionFromElo(t.elo0), fractionFromElo(t.elo1)
+	win := math.Log(p1 / p0)
+	loss := math.Log((1 - p1) / (1 - p0))
+	return float64(s.Wins)*win + float64(s.Losses)*loss + float64(s.Draws)*(win+loss)/2
+}
+
+// Returns the lower and upper bounds on the log-likelihood ratio.  Once the
+// ratio crosses one of them, elo0 (lower) or elo1 (upper) is accepted.
+func (t sprt) bounds() (lower, upper float64) {
+	return math.Log(t.beta / (1 - t.alpha)), math.Log((1 - t.beta) / t.alpha)
+}
+
+// Returns -1 if elo0 is accepted, +1 if elo1 is accepted, or 0 if more
+// games are needed.
+func (t sprt) decide(s score) int {
+	llr := t.llr(s)
+	lower, upper := t.bounds()
+	switch {
+	case llr <= lower:
+		return -1
+	case llr >= upper:
+		return +1
+	}
+	return 0
+}
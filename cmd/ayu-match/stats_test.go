@@ -0,0 +1,92 @@
+package main
+
+import "math"
+import "testing"
+
+func near(x, y, tolerance float64) bool {
+	return math.Abs(x-y) <= tolerance
+}
+
+func TestEloConversions(t *testing.T) {
+	tests := []struct {
+		fraction, elo float64
+	}{
+		{0.5, 0},
+		{0.75, 190.8485},
+		{0.25, -190.8485},
+		{0.6400650, 100},
+		{0.2402531, -200},
+	}
+	for _, test := range tests {
+		if elo := eloFromFraction(test.fraction); !near(elo, test.elo, 1e-3) {
+			t.Errorf("eloFromFraction(%v) = %v, expected %v", test.fraction, elo, test.elo)
+		}
+		if f := fractionFromElo(test.elo); !near(f, test.fraction, 1e-6) {
+			t.Errorf("fractionFromElo(%v) = %v, expected %v", test.elo, f, test.fraction)
+		}
+	}
+}
+
+func TestEloEstimate(t *testing.T) {
+	tests := []struct {
+		s           score
+		elo, margin float64
+	}{
+		{score{50, 50, 0}, 0, 68.9901},
+		{score{60, 40, 0}, 70.4365, 70.5725},
+		{score{30, 20, 50}, 34.8601, 48.4711},
+		{score{40, 60, 0}, -70.4365, 70.5725},
+	}
+	for _, test := range tests {
+		elo, margin := test.s.elo()
+		if !near(elo, test.elo, 1e-3) || !near(margin, test.margin, 1e-3) {
+			t.Errorf("%+v: got %v +/- %v, expected %v +/- %v", test.s, elo, margin, test.elo, test.margin)
+		}
+	}
+}
+
+func TestEloLopsided(t *testing.T) {
+	// The confidence interval reaches a score of 0 or 1.
+	for _, s := range []score{{10, 0, 0}, {0, 10, 0}, {9, 1, 0}} {
+		if _, margin := s.elo(); !math.IsInf(margin, 1) {
+			t.Errorf("%+v: margin %v, expected infinity", s, margin)
+		}
+	}
+}
+
+func TestSprt(t *testing.T) {
+	test := sprt{elo0: 0, elo1: 10, alpha: 0.05, beta: 0.05}
+	lower, upper := test.bounds()
+	if !near(lower, -2.944439, 1e-6) || !near(upper, 2.944439, 1e-6) {
+		t.Errorf("bounds: got %v, %v", lower, upper)
+	}
+	llrs := []struct {
+		s   score
+		llr float64
+	}{
+		{score{1, 0, 0}, 0.0283682},
+		{score{0, 1, 0}, -0.0291965},
+		{score{10, 5, 4}, 0.1360426},
+		{score{0, 0, 10}, -0.0041415},
+	}
+	for _, l := range llrs {
+		if llr := test.llr(l.s); !near(llr, l.llr, 1e-6) {
+			t.Errorf("llr(%+v) = %v, expected %v", l.s, llr, l.llr)
+		}
+	}
+	decisions := []struct {
+		s        score
+		decision int
+	}{
+		{score{0, 0, 0}, 0},
+		{score{103, 0, 0}, 0},
+		{score{104, 0, 0}, +1},
+		{score{0, 101, 0}, -1},
+		{score{500, 500, 0}, 0},
+	}
+	for _, d := range decisions {
+		if decision := test.decide(d.s); decision != d.decision {
+			t.Errorf("decide(%+v) = %d, expected %d", d.s, decision, d.decision)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Runs a tournament between player programs, locally and without a server.
+//
+// The tournament is described by a JSON configuration file, for example:
+//
+//	{
+//		"Players": [
+//			{"Name": "random", "Command": "ayu-engine -strategy random"},
+//			{"Name": "greedy", "Command": "ayu-engine -strategy greedy"},
+//			{"Name": "depth2", "Command": "ayu-engine", "Protocol": "ayu",
+//			 "Options": [["Depth", "2"]]}
+//		],
+//		"Format": "round-robin",
+//		"Rounds": 2,
+//		"Size": 9,
+//		"Time": "1m",
+//		"Increment": "1s"
+//	}
+//
+// Format is "round-robin" (everyone plays everyone, Rounds times), "gauntlet"
+// (the first player plays everyone else, Rounds times) or "swiss" (Rounds
+// rounds).  Progress is saved to a state file after every game; running the
+// command again with the same state file, and without --config, resumes the
+// tournament.
+package main
+
+import "ayu"
+import "ayu/player"
+import "encoding/json"
+import "errors"
+import "flag"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "log"
+import "os"
+import "path"
+import "strconv"
+import "sync"
+import "time"
+
+var config_arg = flag.String("config", "", "Tournament configuration file (JSON)")
+var state_arg = flag.String("state", "tournament.state", "File where tournament progress is saved")
+var records_arg = flag.String("records", "", "Directory where game records are written (optional)")
+var concurrency_arg = flag.Int("concurrency", 1, "Number of games to play at the same time")
+var quiet_arg = flag.Bool("quiet", false, "Don't copy player errors to standard error")
+
+func readConfig(path string) (*tournament, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t tournament
+	if err := json.Unmarshal(data, &t.Config); err != nil {
+		return nil, err
+	}
+	c := &t.Config
+	if len(c.Players) < 2 {
+		return nil, errors.New("Need at least two players.")
+	}
+	for i := range c.Players {
+		if c.Players[i].Protocol == "" {
+			c.Players[i].Protocol = "ayu"
+		}
+		if c.Players[i].Name == "" {
+			c.Players[i].Name = "player" + strconv.Itoa(i+1)
+		}
+	}
+	if c.Rounds <= 0 {
+		c.Rounds = 1
+	}
+	if c.Size == 0 {
+		c.Size = ayu.DefaultSize
+	}
+	if !ayu.IsValidSize(c.Size) {
+		return nil, fmt.Errorf("Invalid board size: %d", c.Size)
+	}
+	if _, err := c.timeControl(); err != nil {
+		return nil, err
+	}
+	if err := schedule(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Plays the game for a single pairing between freshly started players.
+func play(c *config, p *pairing, stderr io.Writer) (player.Result, error) {
+	var procs [2]*player.Process
+	for i, index := range []int{p.White, p.Black} {
+		e := c.Players[index]
+		if proc, err := player.Start(e.Command, e.Protocol, e.Options, stderr); err != nil {
+			if i == 1 {
+				procs[0].Kill()
+			}
+			return player.Result{}, fmt.Errorf("%s: %s", e.Name, err)
+		} else {
+			procs[i] = proc
+		}
+	}
+	tc, _ := c.timeControl()
+	result := player.Play(procs[0], procs[1], c.Size, tc, c.MaxMoves)
+	for _, proc := range procs {
+		if err := proc.Close(time.Second); err != nil {
+			log.Println(err)
+		}
+	}
+	return result, nil
+}
+
+func writeRecord(dir string, number int, c *config, p *pairing, result player.Result) error {
+	f, err := os.Create(path.Join(dir, fmt.Sprintf("game-%04d.txt", number)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tags := [][2]string{
+		{"Round", strconv.Itoa(p.Round)},
+		{"White", c.Players[p.White].Name},
+		{"Black", c.Players[p.Black].Name},
+		{"Size", strconv.Itoa(c.Size)},
+		{"Result", result.String()},
+	}
+	return player.WriteRecord(f, tags, result.State)
+}
+
+// Plays all pending games, using up to concurrency games at the same time.
+// The state file is updated after every game.
+func playPending(t *tournament, concurrency int, stderr io.Writer) error {
+	var mutex sync.Mutex // held while updating t
+	var first_err error
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				p := t.Pairings[i]
+				result, err := play(&t.Config, p, stderr)
+				mutex.Lock()
+				if err != nil {
+					if first_err == nil {
+						first_err = err
+					}
+					mutex.Unlock()
+					continue
+				}
+				p.Done, p.Winner, p.Reason = true, result.Winner, result.Reason
+				p.Moves = nil
+				for _, move := range result.State.History {
+					p.Moves = append(p.Moves, move.String())
+				}
+				fmt.Printf("Round %d: %s - %s: %s\n", p.Round,
+					t.Config.Players[p.White].Name, t.Config.Players[p.Black].Name, result)
+				if err := t.save(*state_arg); err != nil {
+					log.Println("Could not save tournament state:", err)
+				}
+				if *records_arg != "" {
+					if err := writeRecord(*records_arg, i+1, &t.Config, p, result); err != nil {
+						log.Println("Could not write game record:", err)
+					}
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for i, p := range t.Pairings {
+		if !p.Done {
+			mutex.Lock()
+			failed := first_err != nil
+			mutex.Unlock()
+			if failed {
+				break
+			}
+			work <- i
+		}
+	}
+	close(work)
+	wg.Wait()
+	return first_err
+}
+
+func main() {
+	flag.Parse()
+	var t *tournament
+	if _, err := os.Stat(*state_arg); err == nil {
+		if *config_arg != "" {
+			log.Fatalf("%s already exists; remove it to start a new tournament, or resume without --config.", *state_arg)
+		}
+		if t, err = loadTournament(*state_arg); err != nil {
+			log.Fatalln("Could not load tournament state:", err)
+		}
+		log.Println("Resuming tournament from", *state_arg)
+	} else if *config_arg == "" {
+		log.Fatalln("Need a --config to start a new tournament.")
+	} else if t, err = readConfig(*config_arg); err != nil {
+		log.Fatalln("Could not read configuration:", err)
+	}
+	if err := t.save(*state_arg); err != nil {
+		log.Fatalln("Could not save tournament state:", err)
+	}
+	var stderr io.Writer = os.Stderr
+	if *quiet_arg {
+		stderr = ioutil.Discard
+	}
+	concurrency := *concurrency_arg
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for {
+		if err := playPending(t, concurrency, stderr); err != nil {
+			log.Fatalln("Could not play game:", err)
+		}
+		if err := schedule(t); err != nil {
+			log.Fatalln(err)
+		}
+		if allDone(t) {
+			break
+		}
+		if err := t.save(*state_arg); err != nil {
+			log.Fatalln("Could not save tournament state:", err)
+		}
+	}
+	fmt.Println()
+	writeCrosstable(os.Stdout, t)
+	fmt.Println()
+	writeRatings(os.Stdout, t)
+}
@@ -0,0 +1,146 @@
+package main
+
+import "fmt"
+import "io"
+import "math"
+import "sort"
+
+// Writes a crosstable with the points each player scored against each
+// other player, followed by their total.
+func writeCrosstable(w io.Writer, t *tournament) {
+	n := len(t.Config.Players)
+	scored := make([][]float64, n)
+	played := make([][]int, n)
+	for i := range scored {
+		scored[i] = make([]float64, n)
+		played[i] = make([]int, n)
+	}
+	for _, p := range t.Pairings {
+		if !p.Done || p.Black < 0 {
+			continue
+		}
+		played[p.White][p.Black]++
+		played[p.Black][p.White]++
+		switch p.Winner {
+		case 0:
+			scored[p.White][p.Black] += 1
+		case 1:
+			scored[p.Black][p.White] += 1
+		default:
+			scored[p.White][p.Black] += 0.5
+			scored[p.Black][p.White] += 0.5
+		}
+	}
+	width := 6
+	for _, e := range t.Config.Players {
+		if len(e.Name) > width {
+			width = len(e.Name)
+		}
+	}
+	fmt.Fprintf(w, "%3s %-*s", "", width, "")
+	for j := range t.Config.Players {
+		fmt.Fprintf(w, " %7d", j+1)
+	}
+	fmt.Fprintf(w, " %7s\n", "Total")
+	total := points(t)
+	for i, e := range t.Config.Players {
+		fmt.Fprintf(w, "%2d. %-*s", i+1, width, e.Name)
+		for j := range t.Config.Players {
+			if i == j || played[i][j] == 0 {
+				fmt.Fprintf(w, " %7s", "-")
+			} else {
+				fmt.Fprintf(w, " %7s", fmt.Sprintf("%g/%d", scored[i][j], played[i][j]))
+			}
+		}
+		fmt.Fprintf(w, " %7g\n", total[i])
+	}
+}
+
+// Estimates Elo ratings with the minorization-maximization algorithm for
+// the Bradley-Terry model.  A draw counts as half a win for both players.
+// Every pair of players that met gets one extra virtual draw, so that
+// players who won or lost all their games still get a finite rating.  The
+// ratings are shifted so they average to zero.
+func ratings(t *tournament) []float64 {
+	n := len(t.Config.Players)
+	wins := make([]float64, n)
+	games := make([][]float64, n)
+	for i := range games {
+		games[i] = make([]float64, n)
+	}
+	for _, p := range t.Pairings {
+		if !p.Done || p.Black < 0 {
+			continue
+		}
+		if games[p.White][p.Black] == 0 {
+			// Virtual draw.
+			games[p.White][p.Black], games[p.Black][p.White] = 1, 1
+			wins[p.White] += 0.5
+			wins[p.Black] += 0.5
+		}
+		games[p.White][p.Black]++
+		games[p.Black][p.White]++
+		switch p.Winner {
+		case 0:
+			wins[p.White] += 1
+		case 1:
+			wins[p.Black] += 1
+		default:
+			wins[p.White] += 0.5
+			wins[p.Black] += 0.5
+		}
+	}
+	gamma := make([]float64, n)
+	for i := range gamma {
+		gamma[i] = 1
+	}
+	for iter := 0; iter < 1000; iter++ {
+		for i := range gamma {
+			sum := 0.0
+			for j := range gamma {
+				if games[i][j] > 0 {
+					sum += games[i][j] / (gamma[i] + gamma[j])
+				}
+			}
+			if sum > 0 {
+				gamma[i] = wins[i] / sum
+			}
+		}
+	}
+	elo := make([]float64, n)
+	mean := 0.0
+	for i := range elo {
+		elo[i] = 400 * math.Log10(gamma[i])
+		mean += elo[i] / float64(n)
+	}
+	for i := range elo {
+		elo[i] -= mean
+	}
+	return elo
+}
+
+// Writes the players ordered by rating, with their points and number of
+// games played.
+func writeRatings(w io.Writer, t *tournament) {
+	elo := ratings(t)
+	total := points(t)
+	games := make([]int, len(elo))
+	for _, p := range t.Pairings {
+		if p.Done {
+			games[p.White]++
+			if p.Black >= 0 {
+				games[p.Black]++
+			}
+		}
+	}
+	order := make([]int, len(elo))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return elo[order[a]] > elo[order[b]] })
+	fmt.Fprintf(w, "%4s %-20s %6s %6s %5s\n", "Rank", "Name", "Elo", "Points", "Games")
+	for rank, i := range order {
+		fmt.Fprintf(w, "%4d %-20s %+6.0f %6g %5d\n",
+			rank+1, t.Config.Players[i].Name, elo[i], total[i], games[i])
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "math"
+import "testing"
+
+func result(white, black, winner int) *pairing {
+	return &pairing{Round: 1, White: white, Black: black, Done: true, Winner: winner}
+}
+
+func TestRatingsTwoPlayers(t *testing.T) {
+	tour := newTournament(2, "round-robin", 4)
+	// 3 wins and a loss, plus the virtual draw: an expected score of 0.7.
+	tour.Pairings = []*pairing{result(0, 1, 0), result(1, 0, 1), result(0, 1, 0), result(1, 0, 0)}
+	elo := ratings(tour)
+	diff := 400 * math.Log10(0.7/0.3)
+	if math.Abs(elo[0]-diff/2) > 1e-6 || math.Abs(elo[1]+diff/2) > 1e-6 {
+		t.Errorf("Got %v, expected +/- %v", elo, diff/2)
+	}
+}
+
+func TestRatingsSymmetric(t *testing.T) {
+	tour := newTournament(3, "round-robin", 1)
+	// Everyone beats one player and loses to the other.
+	tour.Pairings = []*pairing{result(0, 1, 0), result(1, 2, 0), result(2, 0, 0)}
+	for i, e := range ratings(tour) {
+		if math.Abs(e) > 1e-6 {
+			t.Errorf("Player %d: %v, expected 0", i, e)
+		}
+	}
+}
+
+func TestRatingsOrder(t *testing.T) {
+	tour := newTournament(3, "round-robin", 1)
+	// Player 0 wins all, player 2 loses all, and a bye and an unfinished
+	// game are ignored.
+	tour.Pairings = []*pairing{
+		result(0, 1, 0), result(2, 0, 1), result(1, 2, 0), result(2, -1, 0),
+		{Round: 2, White: 2, Black: 0},
+	}
+	elo := ratings(tour)
+	if !(elo[0] > elo[1] && elo[1] > elo[2]) {
+		t.Errorf("Got %v, expected decreasing ratings", elo)
+	}
+	if math.Abs(elo[0]+elo[1]+elo[2]) > 1e-6 {
+		t.Errorf("Got %v, expected an average of 0", elo)
+	}
+	if math.Abs(elo[0]+elo[2]) > 1e-6 || math.Abs(elo[1]) > 1e-6 {
+		t.Errorf("Got %v, expected symmetric ratings", elo)
+	}
+}
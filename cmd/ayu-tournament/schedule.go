@@ -0,0 +1,186 @@
+package main
+
+import "fmt"
+import "sort"
+
+// Creates all pairings of a round-robin tournament, in which each pair of
+// players meets once per round, with colours alternating between rounds.
+func roundRobin(players, rounds int) (res []*pairing) {
+	for r := 1; r <= rounds; r++ {
+		for i := 0; i < players; i++ {
+			for j := i + 1; j < players; j++ {
+				if (r+i+j)%2 == 1 {
+					res = append(res, &pairing{Round: r, White: i, Black: j})
+				} else {
+					res = append(res, &pairing{Round: r, White: j, Black: i})
+				}
+			}
+		}
+	}
+	return
+}
+
+// Creates all pairings of a gauntlet, in which the first player meets each
+// other player once per round, with colours alternating between rounds.
+func gauntlet(players, rounds int) (res []*pairing) {
+	for r := 1; r <= rounds; r++ {
+		for j := 1; j < players; j++ {
+			if r%2 == 1 {
+				res = append(res, &pairing{Round: r, White: 0, Black: j})
+			} else {
+				res = append(res, &pairing{Round: r, White: j, Black: 0})
+			}
+		}
+	}
+	return
+}
+
+// Returns the number of points scored by each player in completed games.
+func points(t *tournament) []float64 {
+	res := make([]float64, len(t.Config.Players))
+	for _, p := range t.Pairings {
+		if !p.Done {
+			continue
+		}
+		switch {
+		case p.Black < 0 || p.Winner == 0:
+			res[p.White] += 1
+		case p.Winner == 1:
+			res[p.Black] += 1
+		default:
+			res[p.White] += 0.5
+			res[p.Black] += 0.5
+		}
+	}
+	return res
+}
+
+// Creates the pairings for the next round of a Swiss tournament.  Players
+// are ranked by points and paired with the next highest ranked player they
+// haven't met yet, avoiding rematches where possible.  With an odd number
+// of players, the lowest ranked player without a bye so far gets one.
+// Colours go to whoever has played white less often.
+func swissRound(t *tournament, round int) (res []*pairing) {
+	n := len(t.Config.Players)
+	met := make(map[[2]int]bool)
+	whites := make([]int, n)
+	byes := make([]bool, n)
+	for _, p := range t.Pairings {
+		if p.Black < 0 {
+			byes[p.White] = true
+			continue
+		}
+		met[[2]int{p.White, p.Black}] = true
+		met[[2]int{p.Black, p.White}] = true
+		whites[p.White]++
+	}
+	score := points(t)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return score[order[a]] > score[order[b]]
+	})
+	if n%2 == 1 {
+		bye := len(order) - 1
+		for i := len(order) - 1; i >= 0; i-- {
+			if !byes[order[i]] {
+				bye = i
+				break
+			}
+		}
+		res = append(res, &pairing{Round: round, White: order[bye], Black: -1,
+			Done: true, Reason: "bye"})
+		order = append(order[:bye], order[bye+1:]...)
+	}
+	pairs, ok := pairUnmet(order, met)
+	if !ok {
+		pairs = pairGreedy(order, met)
+	}
+	for _, pair := range pairs {
+		i, j := pair[0], pair[1]
+		if whites[i] <= whites[j] {
+			res = append(res, &pairing{Round: round, White: i, Black: j})
+		} else {
+			res = append(res, &pairing{Round: round, White: j, Black: i})
+		}
+	}
+	return
+}
+
+// Pairs the players in order of rank, each with the highest ranked player
+// they haven't met yet, backtracking when that leaves players who can't be
+// paired without a rematch.  Returns false if there is no such pairing.
+func pairUnmet(order []int, met map[[2]int]bool) ([][2]int, bool) {
+	if len(order) == 0 {
+		return nil, true
+	}
+	i := order[0]
+	for k := 1; k < len(order); k++ {
+		if met[[2]int{i, order[k]}] {
+			continue
+		}
+		rest := append(append([]int{}, order[1:k]...), order[k+1:]...)
+		if pairs, ok := pairUnmet(rest, met); ok {
+			return append([][2]int{{i, order[k]}}, pairs...), true
+		}
+	}
+	return nil, false
+}
+
+// Pairs the players in order of rank like pairUnmet, but without
+// backtracking, falling back to a rematch with the next player if everyone
+// else has been met already.
+func pairGreedy(order []int, met map[[2]int]bool) (res [][2]int) {
+	order = append([]int{}, order...)
+	for len(order) > 0 {
+		i := order[0]
+		k := 1
+		for j := 1; j < len(order); j++ {
+			if !met[[2]int{i, order[j]}] {
+				k = j
+				break
+			}
+		}
+		res = append(res, [2]int{i, order[k]})
+		order = append(order[1:k], order[k+1:]...)
+	}
+	return
+}
+
+// Adds the pairings for the next round, if there are more rounds to play.
+// Pairings of round-robin and gauntlet tournaments are all created in the
+// first round, while a Swiss round can only be paired once the previous
+// round is complete.
+func schedule(t *tournament) error {
+	n := len(t.Config.Players)
+	if len(t.Pairings) == 0 {
+		switch t.Config.Format {
+		case "round-robin":
+			t.Pairings = roundRobin(n, t.Config.Rounds)
+			return nil
+		case "gauntlet":
+			t.Pairings = gauntlet(n, t.Config.Rounds)
+			return nil
+		case "swiss":
+		default:
+			return fmt.Errorf("Unknown tournament format: %s", t.Config.Format)
+		}
+	}
+	if t.Config.Format == "swiss" {
+		if round := t.completedRounds(); round < t.Config.Rounds && allDone(t) {
+			t.Pairings = append(t.Pairings, swissRound(t, round+1)...)
+		}
+	}
+	return nil
+}
+
+func allDone(t *tournament) bool {
+	for _, p := range t.Pairings {
+		if !p.Done {
+			return false
+		}
+	}
+	return true
+}
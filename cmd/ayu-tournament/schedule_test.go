@@ -0,0 +1,144 @@
+package main
+
+import "testing"
+
+func newTournament(players int, format string, rounds int) *tournament {
+	t := &tournament{Config: config{Format: format, Rounds: rounds}}
+	t.Config.Players = make([]entrant, players)
+	return t
+}
+
+// Checks that each pair of players meets once per round and that the
+// colours of a pair alternate between rounds.
+func TestRoundRobin(t *testing.T) {
+	const players, rounds = 4, 3
+	pairings := roundRobin(players, rounds)
+	if len(pairings) != rounds*players*(players-1)/2 {
+		t.Fatalf("Got %d pairings", len(pairings))
+	}
+	white := make(map[[3]int]bool) // round, white, black
+	met := make(map[[3]int]int)    // round, lower, higher
+	for _, p := range pairings {
+		if p.White == p.Black || p.White < 0 || p.Black < 0 || p.White >= players || p.Black >= players {
+			t.Fatalf("Invalid pairing %+v", p)
+		}
+		white[[3]int{p.Round, p.White, p.Black}] = true
+		lo, hi := p.White, p.Black
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		met[[3]int{p.Round, lo, hi}]++
+	}
+	for r := 1; r <= rounds; r++ {
+		for i := 0; i < players; i++ {
+			for j := i + 1; j < players; j++ {
+				if n := met[[3]int{r, i, j}]; n != 1 {
+					t.Errorf("Round %d: %d and %d meet %d times", r, i, j, n)
+				}
+				if r > 1 && white[[3]int{r, i, j}] == white[[3]int{r - 1, i, j}] {
+					t.Errorf("Round %d: %d and %d have the same colours as before", r, i, j)
+				}
+			}
+		}
+	}
+}
+
+func TestGauntlet(t *testing.T) {
+	pairings := gauntlet(4, 2)
+	if len(pairings) != 6 {
+		t.Fatalf("Got %d pairings", len(pairings))
+	}
+	for _, p := range pairings {
+		first_white := p.Round%2 == 1
+		if first_white && p.White != 0 || !first_white && p.Black != 0 {
+			t.Errorf("Unexpected colours in %+v", p)
+		}
+	}
+	for j := 1; j < 4; j++ {
+		games := 0
+		for _, p := range pairings {
+			if p.White == j || p.Black == j {
+				games++
+			}
+		}
+		if games != 2 {
+			t.Errorf("Player %d plays %d games", j, games)
+		}
+	}
+}
+
+// Plays a Swiss tournament in which the lower numbered player always wins,
+// and checks the pairings of every round.
+func TestSwiss(t *testing.T) {
+	const players, rounds = 5, 4
+	tour := newTournament(players, "swiss", rounds)
+	byes := make(map[int]bool)
+	met := make(map[[2]int]bool)
+	for r := 1; r <= rounds; r++ {
+		if err := schedule(tour); err != nil {
+			t.Fatal(err)
+		}
+		var round []*pairing
+		for _, p := range tour.Pairings {
+			if p.Round == r {
+				round = append(round, p)
+			}
+		}
+		if len(round) != (players+1)/2 {
+			t.Fatalf("Round %d has %d pairings", r, len(round))
+		}
+		seen := make(map[int]bool)
+		for _, p := range round {
+			if seen[p.White] || seen[p.Black] {
+				t.Errorf("Round %d: a player is paired twice in %+v", r, p)
+			}
+			seen[p.White], seen[p.Black] = true, true
+			if p.Black < 0 {
+				if byes[p.White] {
+					t.Errorf("Round %d: second bye for %d", r, p.White)
+				}
+				byes[p.White] = true
+				continue
+			}
+			if met[[2]int{p.White, p.Black}] {
+				t.Errorf("Round %d: rematch of %d and %d", r, p.White, p.Black)
+			}
+			met[[2]int{p.White, p.Black}], met[[2]int{p.Black, p.White}] = true, true
+			p.Done = true
+			if p.White < p.Black {
+				p.Winner = 0
+			} else {
+				p.Winner = 1
+			}
+		}
+	}
+	if err := schedule(tour); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tour.Pairings); n != rounds*(players+1)/2 {
+		t.Errorf("Scheduled %d pairings after the last round", n)
+	}
+	if r := tour.completedRounds(); r != rounds {
+		t.Errorf("Completed %d rounds, expected %d", r, rounds)
+	}
+}
+
+// A Swiss round is only paired once the previous one is complete.
+func TestSwissWaitsForRound(t *testing.T) {
+	tour := newTournament(4, "swiss", 3)
+	schedule(tour)
+	tour.Pairings[0].Done = true
+	schedule(tour)
+	if len(tour.Pairings) != 2 {
+		t.Errorf("Got %d pairings before the first round is complete", len(tour.Pairings))
+	}
+}
+
+func TestSwissColours(t *testing.T) {
+	tour := newTournament(2, "swiss", 2)
+	tour.Pairings = []*pairing{{Round: 1, White: 0, Black: 1, Done: true, Winner: -1}}
+	p := swissRound(tour, 2)
+	if len(p) != 1 || p[0].White != 1 || p[0].Black != 0 {
+		t.Errorf("Got %+v, expected 1 to play white", p[0])
+	}
+}
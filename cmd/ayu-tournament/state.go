@@ -0,0 +1,98 @@
+package main
+
+import "ayu/player"
+import "encoding/json"
+import "io/ioutil"
+import "os"
+import "time"
+
+// A participant in the tournament.
+type entrant struct {
+	Name     string
+	Command  string
+	Protocol string
+	Options  player.OptionList
+}
+
+// Tournament configuration, read from the file given with --config.
+type config struct {
+	Players   []entrant
+	Format    string // "round-robin", "gauntlet" or "swiss"
+	Rounds    int    // games per pairing, or number of Swiss rounds
+	Size      int
+	Time      string // initial time per player, e.g. "1m"
+	Increment string // added after each move, e.g. "1s"
+	MaxMoves  int
+}
+
+func (c *config) timeControl() (tc player.TimeControl, err error) {
+	if c.Time != "" {
+		if tc.Initial, err = time.ParseDuration(c.Time); err != nil {
+			return
+		}
+	}
+	if c.Increment != "" {
+		tc.Increment, err = time.ParseDuration(c.Increment)
+	}
+	return
+}
+
+// A single game of the tournament.  White and Black index into the list
+// of players; Black is -1 for a bye, which counts as a win for White.
+type pairing struct {
+	Round  int
+	White  int
+	Black  int
+	Done   bool
+	Winner int // 0 (white), 1 (black) or -1 (draw)
+	Reason string
+	Moves  []string
+}
+
+// Everything needed to resume an interrupted tournament.
+type tournament struct {
+	Config   config
+	Pairings []*pairing
+}
+
+func loadTournament(path string) (*tournament, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t tournament
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Saves the tournament state.  The file is replaced atomically, so an
+// interrupted save doesn't lose the results so far.
+func (t *tournament) save(path string) error {
+	data, err := json.MarshalIndent(t, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Returns the number of completed rounds, i.e. the highest round number
+// for which all games have been played.
+func (t *tournament) completedRounds() int {
+	rounds := 0
+	for _, p := range t.Pairings {
+		if p.Round > rounds {
+			rounds = p.Round
+		}
+	}
+	for _, p := range t.Pairings {
+		if !p.Done && p.Round <= rounds {
+			rounds = p.Round - 1
+		}
+	}
+	return rounds
+}
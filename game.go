@@ -2,7 +2,6 @@ package ayu
 
 import "fmt"
 import "io"
-import "math"
 import "regexp"
 import "strconv"
 
@@ -123,10 +122,6 @@ func (s *State) generateMaxMoves(n int) <-chan Move {
 	return ch
 }
 
-func (s *State) generateAllMoves() <-chan Move {
-	return s.generateMaxMoves(math.MaxInt32)
-}
-
 func (c Coords) inRange(f Fields) bool {
 	return 0 <= c[0] && c[0] < len(f) && 0 <= c[1] && c[1] < len(f[c[0]])
 }
@@ -188,6 +183,15 @@ func (f Fields) relabelConnected(c Coords, p int, q int) int {
 	return res
 }
 
+func (f Fields) hasNeighbour(c Coords, p int) bool {
+	for dir := 0; dir < 4; dir++ {
+		if d := c.stepTo(dir); d.inRange(f) && *f.get(d) == p {
+			return true
+		}
+	}
+	return false
+}
+
 func (f Fields) cloneZero() (g Fields) {
 	g = make([][]int, len(f))
 	for i := range f {
@@ -278,8 +282,34 @@ func (s *State) Over() bool {
 	return !ok
 }
 
+// Returns all valid moves for the next player.
+func (s *State) Moves() (moves []Move) {
+	player := s.NextPlayer()
+	for r1 := range s.Fields {
+		for c1 := range s.Fields[r1] {
+			if s.Fields[r1][c1] != player {
+				continue
+			}
+			for r2 := range s.Fields {
+				for c2 := range s.Fields[r2] {
+					// The destination must end up connected to a friendly
+					// unit, so it needs at least one friendly neighbour.
+					dst := Coords{r2, c2}
+					if s.Fields[r2][c2] != 0 || !s.Fields.hasNeighbour(dst, player) {
+						continue
+					}
+					if move := (Move{{r1, c1}, dst}); s.Fields.valid(move) {
+						moves = append(moves, move)
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
 func (s *State) ListMoves() (moves []interface{}) {
-	for move := range s.generateAllMoves() {
+	for _, move := range s.Moves() {
 		moves = append(moves, move)
 	}
 	return
@@ -294,6 +324,22 @@ func (s *State) Execute(arg interface{}) bool {
 	return false
 }
 
+// Takes back the last move played.  Returns false if there is none.
+func (s *State) Undo() bool {
+	if len(s.History) == 0 {
+		return false
+	}
+	m := s.History[len(s.History)-1]
+	s.History = s.History[:len(s.History)-1]
+	s.Fields.swap(m[0], m[1])
+	return true
+}
+
+// Returns a copy of the state that shares no memory with the original.
+func (s *State) Clone() *State {
+	return &State{s.Fields.clone(), append(History{}, s.History...)}
+}
+
 func (s *State) Scores() (int, int) {
 	if s.Over() {
 		if s.Next() == 0 {
@@ -127,3 +127,68 @@ func TestWriteLog(t *testing.T) {
   3. J11-J10  C10-C9
 `)
 }
+
+func TestMoves(t *testing.T) {
+	for _, size := range []int{3, 5, 7, DefaultSize} {
+		state := CreateState(size)
+		for !state.Over() && len(state.History) < 40 {
+			moves := state.Moves()
+			var expected []Move
+			for r1 := 0; r1 < size; r1++ {
+				for c1 := 0; c1 < size; c1++ {
+					for r2 := 0; r2 < size; r2++ {
+						for c2 := 0; c2 < size; c2++ {
+							move := Move{{r1, c1}, {r2, c2}}
+							if state.Valid(move) {
+								expected = append(expected, move)
+							}
+						}
+					}
+				}
+			}
+			if len(moves) != len(expected) {
+				t.Error(size, state.History, expected, moves)
+				break
+			}
+			for i, move := range moves {
+				if expected[i] != move {
+					t.Error(size, state.History, expected[i], move)
+				}
+			}
+			// Play the middle move, to get a variety of positions.
+			if !state.Execute(moves[len(moves)/2]) {
+				t.Error("Could not execute move:", moves[len(moves)/2])
+				break
+			}
+		}
+	}
+}
+
+func TestUndo(t *testing.T) {
+	state := CreateState(DefaultSize)
+	if state.Undo() {
+		t.Error("Undo succeeded without moves")
+	}
+	var before bytes.Buffer
+	state.Fields.WriteBoard(&before)
+	clone := state.Clone()
+	for _, part := range strings.Fields("D9-E9 E10-F10 B9-B10") {
+		move, _ := ParseMove(part)
+		if !state.Execute(move) {
+			t.Error("Could not execute move:", move)
+		}
+	}
+	if len(clone.History) != 0 || clone.Fields[8][3] != 1 {
+		t.Error("Clone shares memory with original")
+	}
+	for i := 0; i < 3; i++ {
+		if !state.Undo() {
+			t.Error("Undo failed", i)
+		}
+	}
+	var after bytes.Buffer
+	state.Fields.WriteBoard(&after)
+	if len(state.History) != 0 || before.String() != after.String() {
+		t.Error(state.History, before.String(), after.String())
+	}
+}
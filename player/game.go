@@ -0,0 +1,130 @@
+package player
+
+import "ayu"
+import "fmt"
+import "time"
+
+// Time control for games played with Play.  A zero Initial time means
+// there is no limit on the total time used.
+type TimeControl struct {
+	Initial   time.Duration
+	Increment time.Duration
+}
+
+// Outcome of a game played with Play.
+type Result struct {
+	Winner int    // 0 (white), 1 (black) or -1 (draw)
+	Reason string // human readable explanation of the outcome
+	State  *ayu.State
+}
+
+func (r Result) String() string {
+	switch r.Winner {
+	case 0:
+		return "1-0 (" + r.Reason + ")"
+	case 1:
+		return "0-1 (" + r.Reason + ")"
+	}
+	return "1/2-1/2 (" + r.Reason + ")"
+}
+
+var colorNames = [2]string{"White", "Black"}
+
+func loss(player int, state *ayu.State, format string, args ...interface{}) Result {
+	return Result{1 - player, colorNames[player] + " " + fmt.Sprintf(format, args...), state}
+}
+
+// How long a player may take to answer after being asked to stop.
+const StopGrace = time.Second
+
+// Asks the player to select a move, waiting at most the given duration (if
+// it is positive).  If the player doesn't answer in time, it is asked to
+// stop if the protocol supports that, and its move is still returned if it
+// answers within StopGrace, so the caller can decide whether it counts.
+// A player that doesn't answer is left in an unknown state and should not
+// be used anymore.
+func SelectMoveWithin(p Protocol, state *ayu.State, clock *Clock, limit time.Duration) (ayu.Move, error) {
+	type reply struct {
+		move ayu.Move
+		err  error
+	}
+	ch := make(chan reply, 1)
+	go func() {
+		move, err := p.SelectMove(state.Clone(), clock)
+		ch <- reply{move, err}
+	}()
+	var timeout <-chan time.Time
+	if limit > 0 {
+		timeout = time.After(limit)
+	}
+	select {
+	case r := <-ch:
+		return r.move, r.err
+	case <-timeout:
+	}
+	if err := p.Stop(); err == nil {
+		select {
+		case r := <-ch:
+			return r.move, r.err
+		case <-time.After(StopGrace):
+		}
+	}
+	return ayu.Move{}, fmt.Errorf("Player did not select a move within %s.", limit)
+}
+
+// Plays a game between the given players on a board of the given size, and
+// adjudicates it locally.  A player loses when it runs out of time, fails
+// to select a move, or selects an invalid move.  If max_moves is positive,
+// the game is declared a draw after that many moves.
+//
+// A player that timed out may still be busy selecting a move when this
+// function returns; it is up to the caller to stop the players.
+func Play(white, black Protocol, size int, tc TimeControl, max_moves int) Result {
+	players := [2]Protocol{white, black}
+	state := ayu.CreateState(size)
+	for i, p := range players {
+		if err := p.NewGame(size); err != nil {
+			return loss(i, state, "could not start game: %s", err)
+		}
+	}
+	var clock *Clock
+	if tc.Initial > 0 {
+		clock = &Clock{
+			[2]time.Duration{tc.Initial, tc.Initial},
+			[2]time.Duration{tc.Increment, tc.Increment}}
+	}
+	for !state.Over() {
+		if max_moves > 0 && len(state.History) >= max_moves {
+			return Result{-1, fmt.Sprintf("%d moves played", max_moves), state}
+		}
+		next := state.Next()
+		var limit time.Duration
+		if clock != nil {
+			limit = clock.Left[next]
+		}
+		start := time.Now()
+		move, err := SelectMoveWithin(players[next], state, clock, limit)
+		if err != nil {
+			if clock != nil && time.Since(start) >= limit {
+				return loss(next, state, "lost on time")
+			}
+			return loss(next, state, "did not select a move: %s", err)
+		}
+		if clock != nil {
+			clock.Left[next] -= time.Since(start)
+			if clock.Left[next] < 0 {
+				return loss(next, state, "lost on time")
+			}
+			clock.Left[next] += clock.Increment[next]
+		}
+		if !state.Execute(move) {
+			return loss(next, state, "played invalid move: %s", move)
+		}
+		if err := players[1-next].OpponentMoved(state); err != nil {
+			return loss(1-next, state, "could not receive move: %s", err)
+		}
+	}
+	// The player to move has no moves left, which means they won.
+	next := state.Next()
+	return Result{next, colorNames[next] + " has no moves left", state}
+}
@@ -0,0 +1,135 @@
+package player
+
+import "bytes"
+import "fmt"
+import "io"
+import "os"
+import "time"
+
+// Resource limits for a player program.  Zero values mean no limit.
+//
+// CPU time, address space, processes and open files are enforced by the
+// operating system through rlimits, which are only supported on Linux.
+// Note that the process limit applies to all processes and threads of the
+// user running the player, not just to the player itself.
+type Limits struct {
+	CPUTime      time.Duration // total CPU time used by the player
+	AddressSpace uint64        // in bytes
+	Processes    uint64
+	OpenFiles    uint64
+
+	// Time from starting the player until it is killed.
+	WallClock time.Duration
+
+	// Directory the player runs in.  If Chroot is set, it also becomes the
+	// player's root directory, and the player command must be given
+	// relative to it.  Changing the root directory requires privileges.
+	Dir    string
+	Chroot bool
+}
+
+func (l *Limits) hasRlimits() bool {
+	return l.CPUTime > 0 || l.AddressSpace > 0 || l.Processes > 0 || l.OpenFiles > 0
+}
+
+// Returns a description of the limit the player exceeded, or an empty
+// string if there is no evidence that it exceeded one.  Waits for the
+// player to exit, so it must only be called after the player failed or was
+// killed.
+//
+// Wall-clock and CPU time are measured.  Exceeding the other limits makes
+// system calls fail, which only shows in the error messages the player
+// prints before exiting.  Other failures are ordinary crashes.
+func (p *Process) LimitExceeded() string {
+	if p.limits == nil {
+		return ""
+	}
+	p.wait()
+	p.mutex.Lock()
+	exceeded, hint := p.wall_clock_exceeded, p.limit_hint
+	p.mutex.Unlock()
+	if exceeded {
+		return fmt.Sprintf("wall-clock time limit of %s", p.limits.WallClock)
+	}
+	state := p.cmd.ProcessState
+	if state == nil || state.Success() {
+		return ""
+	}
+	if p.limits.CPUTime > 0 && (state.UserTime()+state.SystemTime() >= p.limits.CPUTime || killedByCPULimit(state)) {
+		return fmt.Sprintf("CPU time limit of %s", p.limits.CPUTime)
+	}
+	return hint
+}
+
+// Error messages that players print when a limit is exceeded, and the
+// limits they indicate.
+var limit_messages = []struct {
+	message string
+	limit   func(l *Limits) string
+}{
+	{"out of memory", (*Limits).addressSpaceLimit},
+	{"cannot allocate memory", (*Limits).addressSpaceLimit},
+	{"bad_alloc", (*Limits).addressSpaceLimit},
+	{"memoryerror", (*Limits).addressSpaceLimit},
+	{"too many open files", (*Limits).openFilesLimit},
+	{"resource temporarily unavailable", (*Limits).processesLimit},
+}
+
+func (l *Limits) addressSpaceLimit() string {
+	if l.AddressSpace == 0 {
+		return ""
+	}
+	return fmt.Sprintf("address space limit of %d MiB", l.AddressSpace>>20)
+}
+
+func (l *Limits) openFilesLimit() string {
+	if l.OpenFiles == 0 {
+		return ""
+	}
+	return fmt.Sprintf("limit of %d open files", l.OpenFiles)
+}
+
+func (l *Limits) processesLimit() string {
+	if l.Processes == 0 {
+		return ""
+	}
+	return fmt.Sprintf("limit of %d processes", l.Processes)
+}
+
+// Copies the player's standard error, remembering the first message that
+// indicates an exceeded limit.
+type limitWatcher struct {
+	w    io.Writer
+	p    *Process
+	tail []byte // end of the previous write, for messages split across writes
+}
+
+func (lw *limitWatcher) Write(b []byte) (int, error) {
+	text := bytes.ToLower(append(lw.tail, b...))
+	for _, m := range limit_messages {
+		if !bytes.Contains(text, []byte(m.message)) {
+			continue
+		}
+		if limit := m.limit(lw.p.limits); limit != "" {
+			lw.p.mutex.Lock()
+			if lw.p.limit_hint == "" {
+				lw.p.limit_hint = limit
+			}
+			lw.p.mutex.Unlock()
+			break
+		}
+	}
+	if len(text) > 64 {
+		text = text[len(text)-64:]
+	}
+	lw.tail = append(lw.tail[:0], text...)
+	return lw.w.Write(b)
+}
+
+// Prepares the working directory for the player.
+func (l *Limits) makeDir() error {
+	if l.Dir == "" {
+		return nil
+	}
+	return os.MkdirAll(l.Dir, 0755)
+}
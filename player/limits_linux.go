@@ -0,0 +1,87 @@
+package player
+
+import "encoding/json"
+import "fmt"
+import "os"
+import "os/exec"
+import "syscall"
+
+// Limits are applied by re-executing the current program with this
+// environment variable set to the JSON-encoded limits, followed by the path
+// and arguments of the player program.  The init function below then sets
+// the limits and executes the player, so the limits are in place before the
+// player runs its first instruction.
+const limits_env = "AYU_PLAYER_LIMITS"
+
+// Not defined by package syscall, but the same on all common architectures.
+const rlimit_nproc = 6
+
+func init() {
+	if encoded := os.Getenv(limits_env); encoded != "" {
+		if err := execLimited(encoded, os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not start player with limits:", err)
+			os.Exit(127)
+		}
+	}
+}
+
+func execLimited(encoded string, argv []string) error {
+	var l Limits
+	if err := json.Unmarshal([]byte(encoded), &l); err != nil {
+		return err
+	}
+	if len(argv) < 2 {
+		return fmt.Errorf("missing player command")
+	}
+	os.Unsetenv(limits_env)
+	set := func(resource int, soft, hard uint64) error {
+		if soft == 0 {
+			return nil
+		}
+		return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: soft, Max: hard})
+	}
+	// The soft CPU limit sends SIGXCPU; the hard limit a second later kills.
+	cpu := uint64((l.CPUTime.Nanoseconds() + 999999999) / 1e9)
+	if err := set(syscall.RLIMIT_CPU, cpu, cpu+1); err != nil {
+		return err
+	}
+	if err := set(syscall.RLIMIT_AS, l.AddressSpace, l.AddressSpace); err != nil {
+		return err
+	}
+	if err := set(rlimit_nproc, l.Processes, l.Processes); err != nil {
+		return err
+	}
+	if err := set(syscall.RLIMIT_NOFILE, l.OpenFiles, l.OpenFiles); err != nil {
+		return err
+	}
+	if l.Chroot {
+		if err := syscall.Chroot(l.Dir); err != nil {
+			return err
+		}
+		if err := syscall.Chdir("/"); err != nil {
+			return err
+		}
+	}
+	return syscall.Exec(argv[0], argv[1:], os.Environ())
+}
+
+// Changes cmd so it runs the player with the given limits.
+func (l *Limits) apply(cmd *exec.Cmd) error {
+	if !l.hasRlimits() && !l.Chroot {
+		return nil
+	}
+	encoded, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	cmd.Args = append([]string{"ayu-player-limits", cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+	cmd.Env = append(os.Environ(), limits_env+"="+string(encoded))
+	return nil
+}
+
+// Returns whether the player was stopped by the soft CPU time limit.
+func killedByCPULimit(state *os.ProcessState) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGXCPU
+}
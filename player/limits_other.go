@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package player
+
+import "errors"
+import "os"
+import "os/exec"
+
+// Changes cmd so it runs the player with the given limits.
+func (l *Limits) apply(cmd *exec.Cmd) error {
+	if l.hasRlimits() || l.Chroot {
+		return errors.New("Resource limits are only supported on Linux.")
+	}
+	return nil
+}
+
+// Players can't have rlimits on other systems.
+func killedByCPULimit(state *os.ProcessState) bool {
+	return false
+}
@@ -0,0 +1,164 @@
+package player
+
+import "bufio"
+import "errors"
+import "io"
+import "log"
+import "os"
+import "os/exec"
+import "path/filepath"
+import "strings"
+import "sync"
+import "time"
+
+// A running player program.
+type Process struct {
+	cmd       *exec.Cmd
+	in        io.WriteCloser
+	lines     chan string
+	wait_once sync.Once
+	wait_err  error
+
+	limits              *Limits
+	wall_clock_timer    *time.Timer
+	wall_clock_exceeded bool
+	limit_hint          string     // limit exceeded according to the player's errors
+	mutex               sync.Mutex // must be held while accessing fields above
+
+	Protocol
+}
+
+// Reads delimited strings from input and writes them to output, until the
+// end of input is reached.  Closes output when done.
+func readStrings(input io.ReadCloser, delimiter byte, output chan<- string) {
+	reader := bufio.NewReader(input)
+	for {
+		if line, err := reader.ReadString(delimiter); line != "" {
+			output <- line
+		} else if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Println("Error reading from player!", err)
+			break
+		}
+	}
+	close(output)
+}
+
+// Starts the player program given by command, which consists of the path
+// to the executable followed by its arguments, separated by spaces.  The
+// player's standard error and search output are copied to stderr.
+func Start(command string, protocol string, options OptionList, stderr io.Writer) (*Process, error) {
+	return StartWithLimits(command, protocol, options, stderr, nil)
+}
+
+// Like Start, but runs the player with the given resource limits, if limits
+// is not nil.
+func StartWithLimits(command string, protocol string, options OptionList, stderr io.Writer, limits *Limits) (*Process, error) {
+	p := Process{limits: limits}
+	var out, errs io.ReadCloser
+	if argv := strings.Fields(command); len(argv) == 0 {
+		return nil, errors.New("No player command given!")
+	} else if name, err := lookPath(argv[0], limits); err != nil {
+		return nil, errors.New("Can't find player executable!")
+	} else if dir, err := os.Getwd(); err != nil {
+		return nil, errors.New("Can't get current working directory!")
+	} else {
+		p.cmd = &exec.Cmd{Path: name, Args: argv, Dir: dir}
+		if limits != nil {
+			if err := limits.makeDir(); err != nil {
+				return nil, errors.New("Could not create player directory!")
+			} else if limits.Dir != "" && !limits.Chroot {
+				p.cmd.Dir = limits.Dir
+			}
+			if err := limits.apply(p.cmd); err != nil {
+				return nil, err
+			}
+		}
+		if p.in, err = p.cmd.StdinPipe(); err != nil {
+			return nil, errors.New("Could not open player input!")
+		} else if out, err = p.cmd.StdoutPipe(); err != nil {
+			return nil, errors.New("Could not open player output!")
+		} else if errs, err = p.cmd.StderrPipe(); err != nil {
+			return nil, errors.New("Could not open player errors!")
+		} else if err := p.cmd.Start(); err != nil {
+			return nil, errors.New("Could not start player!")
+		}
+	}
+	if limits != nil && limits.WallClock > 0 {
+		p.wall_clock_timer = time.AfterFunc(limits.WallClock, func() {
+			p.mutex.Lock()
+			p.wall_clock_exceeded = true
+			p.mutex.Unlock()
+			p.cmd.Process.Kill()
+		})
+	}
+	if limits != nil && limits.hasRlimits() {
+		go io.Copy(&limitWatcher{w: stderr, p: &p}, errs)
+	} else {
+		go io.Copy(stderr, errs)
+	}
+	p.lines = make(chan string)
+	go readStrings(out, '\n', p.lines)
+	if proto, err := CreateProtocol(protocol, p.in, p.lines, options, log.New(stderr, "", log.LstdFlags)); err != nil {
+		p.Kill()
+		return nil, err
+	} else {
+		p.Protocol = proto
+	}
+	return &p, nil
+}
+
+// Finds the player executable.  A player that runs in a changed root
+// directory is looked up there when it is started.  Otherwise, the path is
+// made absolute, as the player may run in a different directory.
+func lookPath(name string, limits *Limits) (string, error) {
+	if limits != nil && limits.Chroot {
+		return name, nil
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+// Waits for the player to exit, and returns its exit status.  Safe to call
+// more than once.
+func (p *Process) wait() error {
+	p.wait_once.Do(func() {
+		// Drain output so the player doesn't block writing to it.
+		for range p.lines {
+		}
+		p.wait_err = p.cmd.Wait()
+		if p.wall_clock_timer != nil {
+			p.wall_clock_timer.Stop()
+		}
+	})
+	return p.wait_err
+}
+
+// Tells the player the game is over and waits for it to exit.  If it
+// doesn't exit within the given time, it is killed.
+func (p *Process) Close(timeout time.Duration) error {
+	p.Quit()
+	p.in.Close()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- p.wait()
+	}()
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(timeout):
+		p.Kill()
+		return errors.New("Player did not exit; killed it.")
+	}
+}
+
+// Kills the player program and waits for it to exit.  The player can't be
+// used afterwards.
+func (p *Process) Kill() {
+	p.cmd.Process.Kill()
+	p.wait()
+}
@@ -0,0 +1,414 @@
+// Package player runs player programs and talks to them using one of the
+// protocols described in PROTOCOL.txt.
+package player
+
+import "ayu"
+import "errors"
+import "fmt"
+import "io"
+import "log"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// The version of the ayu protocol implemented by the client.
+const ayu_protocol_version = 1
+
+// A Protocol describes how to talk to a player program.
+// See PROTOCOL.txt for a description of the supported protocols.
+type Protocol interface {
+	// Called once before the first move, with the board size of the game.
+	NewGame(size int) error
+
+	// Called after NewGame when joining a game in progress, and after moves
+	// were taken back, with the moves played so far and the player's colour
+	// (0 for white, 1 for black).
+	Resume(state *ayu.State, color int) error
+
+	// Called after the opponent played the last move in state.
+	OpponentMoved(state *ayu.State) error
+
+	// Asks the player to select a move in the given state.  Clock may be
+	// nil if the game is played without a time control.
+	SelectMove(state *ayu.State, clock *Clock) (ayu.Move, error)
+
+	// Asks the player to answer a SelectMove call in progress as soon as
+	// possible.  May be called concurrently with SelectMove.  Returns an
+	// error if the protocol has no way to do that.
+	Stop() error
+
+	// Called when the game is over.
+	Quit()
+}
+
+// Time left on the clock for white and black (indexed like State.Next())
+// and their increments per move.
+type Clock struct {
+	Left      [2]time.Duration
+	Increment [2]time.Duration
+}
+
+// Engine options, as name/value pairs.  Implements flag.Value so it can be
+// passed on the command line as repeated name=value arguments.
+type OptionList [][2]string
+
+func (l *OptionList) String() string {
+	var parts []string
+	for _, opt := range *l {
+		parts = append(parts, opt[0]+"="+opt[1])
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *OptionList) Set(s string) error {
+	if i := strings.Index(s, "="); i <= 0 {
+		return errors.New("Option must have the form name=value.")
+	} else {
+		*l = append(*l, [2]string{s[:i], s[i+1:]})
+		return nil
+	}
+}
+
+// Names of the protocols supported by CreateProtocol.
+const ProtocolNames = "legacy or ayu"
+
+// Creates a protocol with the given name that writes commands to in and
+// reads responses from lines.  Search output from the player is written to
+// logger.
+func CreateProtocol(name string, in io.Writer, lines <-chan string, options OptionList, logger *log.Logger) (Protocol, error) {
+	switch name {
+	case "legacy":
+		if len(options) > 0 {
+			return nil, errors.New("Legacy protocol does not support options!")
+		}
+		return &legacyProtocol{in, lines}, nil
+	case "ayu":
+		p := &ayuProtocol{in: in, lines: lines, options: options, logger: logger}
+		for _, opt := range options {
+			if strings.EqualFold(opt[0], "ponder") && opt[1] == "true" {
+				p.ponder = true
+			}
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("Unknown protocol: %s", name)
+}
+
+func readLine(lines <-chan string) (string, error) {
+	if line, ok := <-lines; !ok {
+		return "", errors.New("Player closed its output!")
+	} else {
+		return strings.TrimSpace(line), nil
+	}
+}
+
+type legacyProtocol struct {
+	in    io.Writer
+	lines <-chan string
+}
+
+func (p *legacyProtocol) NewGame(size int) error {
+	// Only announce the size if it's not the default, for the benefit of
+	// older players that don't understand the command.
+	if size != ayu.DefaultSize {
+		_, err := fmt.Fprintln(p.in, "Size", size)
+		return err
+	}
+	return nil
+}
+
+// The legacy protocol has no way to set up a position, so a game can only
+// be resumed if the opponent played the first move and we didn't reply yet.
+func (p *legacyProtocol) Resume(state *ayu.State, color int) error {
+	if len(state.History) == 1 && color == 1 {
+		return p.OpponentMoved(state)
+	}
+	return errors.New("Legacy protocol can't resume a game in progress.")
+}
+
+func (p *legacyProtocol) OpponentMoved(state *ayu.State) error {
+	last_move := state.History[len(state.History)-1]
+	_, err := fmt.Fprintln(p.in, last_move)
+	return err
+}
+
+func (p *legacyProtocol) SelectMove(state *ayu.State, clock *Clock) (ayu.Move, error) {
+	if len(state.History) == 0 {
+		if _, err := fmt.Fprintln(p.in, "Start"); err != nil {
+			return ayu.Move{}, err
+		}
+	}
+	if move_str, err := readLine(p.lines); err != nil {
+		return ayu.Move{}, err
+	} else if move, ok := ayu.ParseMove(move_str); !ok {
+		return ayu.Move{}, fmt.Errorf("Could not parse move: %s", move_str)
+	} else {
+		return move, nil
+	}
+}
+
+func (p *legacyProtocol) Stop() error {
+	return errors.New("Legacy protocol can't stop a player.")
+}
+
+func (p *legacyProtocol) Quit() {
+	fmt.Fprintln(p.in, "Quit")
+}
+
+type ayuProtocol struct {
+	in         io.Writer
+	lines      <-chan string
+	options    OptionList
+	logger     *log.Logger
+	handshaken bool
+	name       string
+
+	// Pondering, enabled with the option Ponder=true
+	ponder      bool
+	pondering   bool     // searching the position after ponder_move
+	ponder_move ayu.Move // the opponent's move the player expects
+	ponder_hit  bool     // the opponent played it; bestmove follows
+
+	searching bool       // waiting for bestmove to answer a go command
+	mutex     sync.Mutex // must be held while sending commands and accessing searching
+}
+
+func (p *ayuProtocol) send(command string, args ...interface{}) error {
+	line := command
+	for _, arg := range args {
+		line += " " + fmt.Sprint(arg)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	_, err := fmt.Fprintln(p.in, line)
+	return err
+}
+
+func (p *ayuProtocol) setSearching(searching bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.searching = searching
+}
+
+// Reads lines from the player until one starting with the given command is
+// found, and returns its arguments.  Lines starting with "info" are logged;
+// other lines are passed to handle, if it isn't nil.
+func (p *ayuProtocol) expect(command string, handle func(fields []string)) ([]string, error) {
+	for {
+		line, err := readLine(p.lines)
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == command:
+			return fields[1:], nil
+		case fields[0] == "info":
+			p.logger.Println(p.name, line)
+		case handle != nil:
+			handle(fields)
+		}
+	}
+}
+
+func (p *ayuProtocol) handshake() error {
+	if err := p.send("ayu", ayu_protocol_version); err != nil {
+		return err
+	}
+	args, err := p.expect("ayuok", func(fields []string) {
+		if len(fields) >= 3 && fields[0] == "id" && fields[1] == "name" {
+			p.name = strings.Join(fields[2:], " ")
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("Player did not send a protocol version!")
+	} else if version, err := strconv.Atoi(args[0]); err != nil ||
+		version < 1 || version > ayu_protocol_version {
+		return fmt.Errorf("Unsupported protocol version: %s", args[0])
+	}
+	if p.name != "" {
+		p.logger.Println("Player identified as:", p.name)
+	}
+	for _, opt := range p.options {
+		if err := p.send("setoption name", opt[0], "value", opt[1]); err != nil {
+			return err
+		}
+	}
+	p.handshaken = true
+	return p.sync()
+}
+
+func (p *ayuProtocol) sync() error {
+	if err := p.send("isready"); err != nil {
+		return err
+	}
+	_, err := p.expect("readyok", nil)
+	return err
+}
+
+func (p *ayuProtocol) NewGame(size int) error {
+	if !p.handshaken {
+		if err := p.handshake(); err != nil {
+			return err
+		}
+	}
+	if err := p.send("newgame size", size); err != nil {
+		return err
+	}
+	return p.sync()
+}
+
+// Sets up the position as a board, so the player needs no history.  The
+// moves are sent again with the next search anyway.
+func (p *ayuProtocol) Resume(state *ayu.State, color int) error {
+	if err := p.stopPondering(); err != nil {
+		return err
+	}
+	rows := make([]string, len(state.Fields))
+	for r, row := range state.Fields {
+		b := make([]byte, len(row))
+		for c, v := range row {
+			b[c] = "-.+"[v+1]
+		}
+		rows[r] = string(b)
+	}
+	return p.send("position board", strings.Join(rows, "/"), []string{"w", "b"}[state.Next()])
+}
+
+func (p *ayuProtocol) OpponentMoved(state *ayu.State) error {
+	// The position is sent along with the next search, unless the player
+	// is pondering.
+	if !p.pondering {
+		return nil
+	}
+	if state.History[len(state.History)-1] == p.ponder_move {
+		p.pondering = false
+		p.ponder_hit = true
+		return p.send("ponderhit")
+	}
+	return p.stopPondering()
+}
+
+// Stops the player's search of an expected position, if any, and discards
+// its result.
+func (p *ayuProtocol) stopPondering() error {
+	if !p.pondering {
+		return nil
+	}
+	p.pondering = false
+	if err := p.send("stop"); err != nil {
+		return err
+	}
+	_, err := p.expect("bestmove", nil)
+	return err
+}
+
+func (p *ayuProtocol) sendPosition(history ayu.History) error {
+	position := []interface{}{"startpos"}
+	if len(history) > 0 {
+		position = append(position, "moves")
+		for _, move := range history {
+			position = append(position, move)
+		}
+	}
+	return p.send("position", position...)
+}
+
+// Returns the arguments of a go command for the clock.
+func goLimits(clock *Clock) []interface{} {
+	var limits []interface{}
+	if clock != nil {
+		for i, name := range []string{"wtime", "btime"} {
+			limits = append(limits, name, clock.Left[i].Nanoseconds()/1e6)
+		}
+		for i, name := range []string{"winc", "binc"} {
+			if clock.Increment[i] > 0 {
+				limits = append(limits, name, clock.Increment[i].Nanoseconds()/1e6)
+			}
+		}
+	}
+	return limits
+}
+
+func (p *ayuProtocol) SelectMove(state *ayu.State, clock *Clock) (ayu.Move, error) {
+	if p.ponder_hit {
+		// The player is already searching this position.
+		p.ponder_hit = false
+		p.setSearching(true)
+	} else {
+		if err := p.stopPondering(); err != nil {
+			return ayu.Move{}, err
+		}
+		if err := p.sendPosition(state.History); err != nil {
+			return ayu.Move{}, err
+		}
+		p.setSearching(true)
+		if err := p.send("go", goLimits(clock)...); err != nil {
+			return ayu.Move{}, err
+		}
+	}
+	args, err := p.expect("bestmove", nil)
+	p.setSearching(false)
+	if err != nil {
+		return ayu.Move{}, err
+	} else if len(args) == 0 {
+		return ayu.Move{}, errors.New("Player sent bestmove without a move!")
+	}
+	move, ok := ayu.ParseMove(args[0])
+	if !ok {
+		return ayu.Move{}, fmt.Errorf("Could not parse move: %s", args[0])
+	}
+	if p.ponder && len(args) >= 3 && args[1] == "ponder" {
+		if err := p.startPondering(state, move, args[2], clock); err != nil {
+			return ayu.Move{}, err
+		}
+	}
+	return move, nil
+}
+
+// Lets the player search the position after its move and the reply it
+// expects while the opponent thinks.  The clock is the one the move was
+// selected with, since the player's own time is only known later.
+func (p *ayuProtocol) startPondering(state *ayu.State, move ayu.Move, reply string, clock *Clock) error {
+	expected, ok := ayu.ParseMove(reply)
+	if !ok {
+		return nil
+	}
+	after := state.Clone()
+	if !after.Execute(move) || !after.Execute(expected) || after.Over() {
+		return nil
+	}
+	if err := p.sendPosition(after.History); err != nil {
+		return err
+	}
+	if err := p.send("go", append([]interface{}{"ponder"}, goLimits(clock)...)...); err != nil {
+		return err
+	}
+	p.pondering = true
+	p.ponder_move = expected
+	return nil
+}
+
+// Sends stop if the player is searching for a move to play.  A stop that
+// crosses the player's bestmove is ignored by the player, since it isn't
+// searching anymore.
+func (p *ayuProtocol) Stop() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.searching {
+		_, err := fmt.Fprintln(p.in, "stop")
+		return err
+	}
+	return nil
+}
+
+func (p *ayuProtocol) Quit() {
+	p.send("quit")
+}
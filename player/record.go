@@ -0,0 +1,20 @@
+package player
+
+import "ayu"
+import "fmt"
+import "io"
+
+// Writes a record of a game: a header with one [Name "value"] line per
+// tag, followed by an empty line and the list of moves played.
+func WriteRecord(w io.Writer, tags [][2]string, state *ayu.State) error {
+	for _, tag := range tags {
+		if _, err := fmt.Fprintf(w, "[%s %q]\n", tag[0], tag[1]); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintln(w); err != nil {
+		return err
+	}
+	_, err := state.WriteLog(w)
+	return err
+}
@@ -0,0 +1,156 @@
+package server
+
+import "encoding/json"
+import "io/ioutil"
+import "log"
+import "net/http"
+import "time"
+
+// Games can be aborted until this many moves have been played.
+const abort_max_moves = 2
+
+// Actions a player can take besides moving, each served at /<action>.
+const (
+	action_resign       = "resign"
+	action_offer_draw   = "offer_draw"
+	action_accept_draw  = "accept_draw"
+	action_decline_draw = "decline_draw"
+	action_abort        = "abort"
+	action_takeback     = "takeback"
+	action_accept_back  = "accept_takeback"
+	action_decline_back = "decline_takeback"
+)
+
+// Returns the player (0 for white, 1 for black) with the given key, or -1.
+func (g *game) playerWithKey(key string) int {
+	for p, k := range g.Keys {
+		if key == k {
+			return p
+		}
+	}
+	return -1
+}
+
+// Performs an action on behalf of the player with the given key, saves the
+// game and notifies waiting clients.  The game mutex must be held.  Returns
+// an HTTP status code and message if the action is not allowed.
+func (g *game) applyAction(db Saver, key string, action string) (int, string) {
+	if g.over() {
+		return 403, "Game Over"
+	}
+	player := g.playerWithKey(key)
+	if player < 0 {
+		return 403, "Forbidden"
+	}
+	sign := 1 - 2*player // +1 for white, -1 for black
+	switch action {
+	case action_resign:
+		g.end(db, -sign, color_names[player]+" resigned")
+	case action_offer_draw:
+		if g.DrawOffer == -sign {
+			// Both players want a draw.
+			g.end(db, 0, "Draw agreed")
+		} else if g.DrawOffer == sign {
+			return 409, "Draw already offered"
+		} else {
+			g.DrawOffer = sign
+			g.changed(db)
+		}
+	case action_accept_draw:
+		if g.DrawOffer != -sign {
+			return 409, "No draw offered"
+		}
+		g.end(db, 0, "Draw agreed")
+	case action_decline_draw:
+		if g.DrawOffer != -sign {
+			return 409, "No draw offered"
+		}
+		g.DrawOffer = 0
+		g.changed(db)
+	case action_abort:
+		if g.version() >= abort_max_moves {
+			return 403, "Too late to abort"
+		}
+		g.Result = &gameResult{Winner: 0, Reason: color_names[player] + " aborted the game", Aborted: true}
+		g.DrawOffer = 0
+		g.Takeback = 0
+		g.changed(db)
+	case action_takeback:
+		// Black's first move is the second move of the game.
+		if g.version() <= player {
+			return 409, "No move to take back"
+		}
+		if g.Takeback != 0 {
+			return 409, "Takeback already requested"
+		}
+		g.Takeback = sign
+		g.changed(db)
+	case action_accept_back:
+		if g.Takeback != -sign {
+			return 409, "No takeback requested"
+		}
+		g.takeBack(db, 1-player, time.Now())
+	case action_decline_back:
+		if g.Takeback != -sign {
+			return 409, "No takeback requested"
+		}
+		g.Takeback = 0
+		g.changed(db)
+	default:
+		return 404, "Not Found"
+	}
+	return 200, ""
+}
+
+// Takes back moves until it is the given player's turn again: their last
+// move, and the opponent's reply if there was one.  The player to move is
+// charged for the time used so far, and the clock restarts for the player
+// to move after the takeback.  The game mutex must be held.
+func (g *game) takeBack(db Saver, player int, now time.Time) {
+	g.chargeClock(now)
+	g.State.Undo()
+	for g.State.Next() != player {
+		g.State.Undo()
+	}
+	if g.version() == 0 {
+		// As at the start of the game, the clocks start after the first move.
+		g.LastTime = time.Time{}
+	}
+	g.Takeback = 0
+	g.DrawOffer = 0
+	g.changed(db)
+}
+
+// Returns a handler for the given action.  Requests are JSON objects with
+// the game id and the key of the player taking the action.
+func actionHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method Not Allowed", 405)
+			return
+		}
+		log.Print("POST /" + action)
+
+		var request struct {
+			Game string
+			Key  string
+		}
+		if body, err := ioutil.ReadAll(r.Body); err != nil {
+			http.Error(w, "Internal Server Error", 500)
+			return
+		} else if err := json.Unmarshal(body, &request); err != nil {
+			http.Error(w, "Bad Request\n"+err.Error(), 400)
+			return
+		}
+		game := getGame(r, request.Game)
+		if game == nil {
+			http.Error(w, "Not Found", 404)
+			return
+		}
+		game.mutex.Lock()
+		defer game.mutex.Unlock()
+		if status, message := game.applyAction(getDatabase(r), request.Key, action); status != 200 {
+			http.Error(w, message, status)
+		}
+	}
+}
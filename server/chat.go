@@ -0,0 +1,138 @@
+package server
+
+import "encoding/json"
+import "io/ioutil"
+import "log"
+import "net"
+import "net/http"
+import "strings"
+import "time"
+import "unicode/utf8"
+
+// Chat channels of a game.  Only the players (identified by their keys) can
+// write to and read the player channel.  The spectator channel is open to anyone, if
+// it was enabled when the game was created.
+const (
+	chat_players    = "players"
+	chat_spectators = "spectators"
+)
+
+// Limits on chat messages.
+const (
+	max_chat_length   = 500         // characters per message
+	max_chat_name     = 20          // characters per spectator name
+	max_chat_messages = 200         // messages kept per game; older ones are dropped
+	chat_interval     = time.Second // minimum time between messages per sender
+)
+
+type chatMessage struct {
+	Channel string    `json:"channel"`
+	From    string    `json:"from"`
+	Text    string    `json:"text"`
+	Time    time.Time `json:"time"`
+}
+
+// A chat message as sent by a client.
+type chatRequest struct {
+	Channel string
+	Key     string
+	Name    string // spectators only
+	Text    string
+}
+
+// Adds a chat message, saves the game and notifies waiting clients.  Sender
+// identifies spectators for rate limiting, e.g. by their IP address.  The
+// game mutex must be held.  Returns an HTTP status code and message if the
+// message is rejected.
+func (g *game) addChat(db Saver, chat *chatRequest, sender string) (int, string) {
+	text := strings.TrimSpace(chat.Text)
+	if text == "" {
+		return 400, "Empty message"
+	}
+	if utf8.RuneCountInString(text) > max_chat_length {
+		return 400, "Message too long"
+	}
+	var from string
+	switch chat.Channel {
+	case chat_players:
+		player := g.playerWithKey(chat.Key)
+		if player < 0 {
+			return 403, "Forbidden"
+		}
+		from = color_names[player]
+		sender = from
+	case chat_spectators:
+		if !g.SpectatorChat {
+			return 403, "Spectator chat is disabled"
+		}
+		from = strings.TrimSpace(chat.Name)
+		if from == "" {
+			from = "Spectator"
+		}
+		if utf8.RuneCountInString(from) > max_chat_name {
+			return 400, "Name too long"
+		}
+	default:
+		return 400, "Unknown chat channel"
+	}
+	now := time.Now()
+	if now.Sub(g.chat_times[sender]) < chat_interval {
+		return 429, "Too Many Requests"
+	}
+	if g.chat_times == nil {
+		g.chat_times = make(map[string]time.Time)
+	}
+	// Only recent messages matter for rate limiting.
+	for s, t := range g.chat_times {
+		if now.Sub(t) >= chat_interval {
+			delete(g.chat_times, s)
+		}
+	}
+	g.chat_times[sender] = now
+
+	g.Chat = append(g.Chat, chatMessage{Channel: chat.Channel, From: from, Text: text, Time: now})
+	if len(g.Chat) > max_chat_messages {
+		g.Chat = append([]chatMessage{}, g.Chat[len(g.Chat)-max_chat_messages:]...)
+	}
+	g.changed(db)
+	return 200, ""
+}
+
+// Returns the host part of the client's address, used to rate limit
+// spectators.
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func handleChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", 405)
+		return
+	}
+	log.Print("POST /chat")
+
+	var chat struct {
+		Game string
+		chatRequest
+	}
+	if body, err := ioutil.ReadAll(r.Body); err != nil {
+		http.Error(w, "Internal Server Error", 500)
+		return
+	} else if err := json.Unmarshal(body, &chat); err != nil {
+		http.Error(w, "Bad Request\n"+err.Error(), 400)
+		return
+	}
+	game := getGame(r, chat.Game)
+	if game == nil {
+		http.Error(w, "Not Found", 404)
+		return
+	}
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	if status, message := game.addChat(getDatabase(r), &chat.chatRequest, remoteHost(r)); status != 200 {
+		http.Error(w, message, status)
+	}
+}
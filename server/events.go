@@ -0,0 +1,94 @@
+package server
+
+import "fmt"
+import "io"
+import "io/ioutil"
+import "log"
+import "net/http"
+import "strconv"
+import "time"
+
+// Streams the game state as server-sent events whenever the game changes.
+// Each event carries the same JSON as /poll, with the game revision as its
+// id, so a client that reconnects with Last-Event-ID only receives the state
+// again once it has changed.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", 405)
+		return
+	}
+	log.Print("GET /events")
+
+	id := r.FormValue("game")
+	game := getGame(r, id)
+	if game == nil {
+		http.Error(w, "Not Found", 404)
+		return
+	}
+	revision := -1
+	if last_event_id := r.Header.Get("Last-Event-ID"); last_event_id != "" {
+		if v, err := strconv.Atoi(last_event_id); err != nil {
+			http.Error(w, "Bad Request\nInvalid Last-Event-ID.", 400)
+			return
+		} else {
+			revision = v
+		}
+	}
+	player := game.playerWithKey(r.FormValue("key")) >= 0
+
+	// Clients that don't accept writes in time are dropped, which needs
+	// deadlines on the connection, so we take it over where possible.
+	// Otherwise, as on App Engine, the response is only flushed.
+	var send func(text string) error
+	done := r.Context().Done()
+	if hijacker, ok := w.(http.Hijacker); ok && r.ProtoMajor == 1 {
+		conn, rw, err := hijacker.Hijack()
+		if err != nil {
+			log.Printf("Events for game %s: %s", id, err)
+			return
+		}
+		defer conn.Close()
+		send = func(text string) error {
+			conn.SetWriteDeadline(time.Now().Add(write_timeout))
+			if _, err := rw.WriteString(text); err != nil {
+				return err
+			}
+			return rw.Flush()
+		}
+		if err := send("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\n" +
+			"Cache-Control: no-cache\r\nConnection: close\r\n\r\n"); err != nil {
+			return
+		}
+		// Clients don't send anything after the request, so reading only
+		// returns once they go away.
+		closed := make(chan struct{})
+		go func() {
+			io.Copy(ioutil.Discard, rw.Reader)
+			close(closed)
+		}()
+		done = closed
+	} else if flusher, ok := w.(http.Flusher); ok {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(200)
+		flusher.Flush()
+		send = func(text string) error {
+			if _, err := io.WriteString(w, text); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		}
+	} else {
+		http.Error(w, "Not Implemented\nStreaming is not supported.", 501)
+		return
+	}
+
+	// Comments sent while the game is idle keep proxies from dropping the
+	// connection, and let us notice when the client went away.
+	game.followUpdates(revision, player, done, func(revision int, state []byte, result *gameResult) error {
+		return send(fmt.Sprintf("id: %d\ndata: %s\n\n", revision, state))
+	}, func() error {
+		return send(": ping\n\n")
+	})
+}
@@ -0,0 +1,336 @@
+package server
+
+import "ayu"
+import "crypto/rand"
+import "encoding/json"
+import "io/ioutil"
+import "log"
+import "net/http"
+import "sort"
+import "sync"
+import "time"
+
+// Seeks stay open while the seeker is waiting at /seek for an opponent, and
+// for this long after a wait ends, so the seeker can reconnect.
+const seek_grace = 10 * time.Second
+
+// An open invitation to play, posted in the lobby, or a player waiting in
+// the matchmaking queue.
+type seek struct {
+	Id          string        `json:"id"`
+	Size        int           `json:"size"`
+	TimeControl TimeControl   `json:"timeControl"`
+	Color       string        `json:"color"` // seeker's colour: "white", "black" or "" for either
+	Created     time.Time     `json:"created"`
+	secret      string        // lets the seeker wait for and cancel the seek
+	waiting     int           // number of seeker requests waiting for an opponent
+	seen        time.Time     // when the seeker last stopped waiting
+	seat        *seat         // seeker's place in the game, once accepted
+	accepted    chan struct{} // closed when the seek is accepted
+
+	// Matchmaking only
+	queued           bool
+	rating           int
+	any_size         bool
+	any_time_control bool
+}
+
+// A player's place in a game created from the lobby.
+type seat struct {
+	Game  string `json:"game"`
+	Key   string `json:"key"`
+	Color string `json:"color"`
+	Size  int    `json:"size"`
+}
+
+var seeks = make(map[string]*seek)
+var seeks_mutex sync.Mutex // must be held while accessing seeks
+
+// Returns whether the seeker has gone away.  The seeks mutex must be held.
+func (s *seek) expired(now time.Time) bool {
+	return s.waiting == 0 && now.Sub(s.seen) > seek_grace
+}
+
+// Removes seeks whose seekers went away.  The seeks mutex must be held.
+func purgeSeeks(now time.Time) {
+	for id, s := range seeks {
+		if s.expired(now) {
+			delete(seeks, id)
+		}
+	}
+}
+
+// Creates a listed game and returns the seats of its two players, where
+// the first player gets the given colour ("" for a random one).  Returns
+// nil if the game could not be created.
+func startSeatedGame(size int, tc TimeControl, color string) (first *seat, second *seat) {
+	g := createGame(size, tc, false, true, "")
+	if g == nil {
+		return nil, nil
+	}
+	first_player := 0
+	switch color {
+	case "white":
+	case "black":
+		first_player = 1
+	default:
+		var b [1]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			log.Fatalln(err)
+		}
+		first_player = int(b[0] & 1)
+	}
+	seatFor := func(p int) *seat {
+		return &seat{Game: g.id, Key: g.Keys[p], Color: []string{"white", "black"}[p], Size: size}
+	}
+	return seatFor(first_player), seatFor(1 - first_player)
+}
+
+// Posts a seek (POST), or waits for a seek to be accepted (GET, with the
+// seek id and secret).  Waiting returns the seeker's seat once an opponent
+// accepted, or 204 "No Content" after the poll delay.  Players in the
+// matchmaking queue wait for their opponent here too.
+func handleSeek(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		postSeek(w, r)
+	case "GET":
+		waitForSeek(w, r)
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+}
+
+func postSeek(w http.ResponseWriter, r *http.Request) {
+	log.Print("POST /seek")
+	var request struct {
+		Size        int
+		TimeControl TimeControl
+		Color       string
+	}
+	if body, err := ioutil.ReadAll(r.Body); err != nil {
+		http.Error(w, "Internal Server Error", 500)
+		return
+	} else if err := json.Unmarshal(body, &request); err != nil {
+		http.Error(w, "Bad Request\n"+err.Error(), 400)
+		return
+	}
+	if request.Size == 0 {
+		request.Size = ayu.DefaultSize
+	}
+	if !ayu.IsValidSize(request.Size) {
+		http.Error(w, "Bad Request\nInvalid board size.", 400)
+		return
+	}
+	if err := request.TimeControl.validate(); err != nil {
+		http.Error(w, "Bad Request\n"+err.Error(), 400)
+		return
+	}
+	if request.Color != "" && request.Color != "white" && request.Color != "black" {
+		http.Error(w, "Bad Request\nColour must be white, black or empty.", 400)
+		return
+	}
+	now := time.Now()
+	s := &seek{
+		Id:          createRandomKey(),
+		Size:        request.Size,
+		TimeControl: request.TimeControl,
+		Color:       request.Color,
+		Created:     now,
+		secret:      createRandomKey(),
+		seen:        now,
+		accepted:    make(chan struct{})}
+	seeks_mutex.Lock()
+	purgeSeeks(now)
+	seeks[s.Id] = s
+	seeks_mutex.Unlock()
+	writeJsonResponse(w, map[string]interface{}{"seek": s.Id, "secret": s.secret})
+}
+
+func waitForSeek(w http.ResponseWriter, r *http.Request) {
+	log.Print("GET /seek")
+	seeks_mutex.Lock()
+	s := seeks[r.FormValue("seek")]
+	if s == nil || s.expired(time.Now()) {
+		seeks_mutex.Unlock()
+		http.Error(w, "Not Found", 404)
+		return
+	}
+	if s.secret != r.FormValue("secret") {
+		seeks_mutex.Unlock()
+		http.Error(w, "Forbidden", 403)
+		return
+	}
+	s.waiting++
+	seeks_mutex.Unlock()
+
+	timed_out := false
+	select {
+	case <-s.accepted:
+	case <-r.Context().Done():
+		timed_out = true
+	case <-time.After(poll_delay):
+		timed_out = true
+	}
+
+	seeks_mutex.Lock()
+	defer seeks_mutex.Unlock()
+	s.waiting--
+	s.seen = time.Now()
+	w.Header().Set("Cache-Control", "no-cache")
+	if timed_out {
+		w.WriteHeader(204) // HTTP 204 "No Content"
+		return
+	}
+	// The seeker knows where to play now.
+	delete(seeks, s.Id)
+	writeJsonResponse(w, s.seat)
+}
+
+// Withdraws a seek, given its id and secret.
+func handleCancelSeek(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", 405)
+		return
+	}
+	log.Print("POST /cancel_seek")
+	var request struct {
+		Seek   string
+		Secret string
+	}
+	if body, err := ioutil.ReadAll(r.Body); err != nil {
+		http.Error(w, "Internal Server Error", 500)
+		return
+	} else if err := json.Unmarshal(body, &request); err != nil {
+		http.Error(w, "Bad Request\n"+err.Error(), 400)
+		return
+	}
+	seeks_mutex.Lock()
+	defer seeks_mutex.Unlock()
+	s := seeks[request.Seek]
+	if s == nil {
+		http.Error(w, "Not Found", 404)
+		return
+	}
+	if s.secret != request.Secret {
+		http.Error(w, "Forbidden", 403)
+		return
+	}
+	if s.seat != nil {
+		http.Error(w, "Conflict\nThe seek was already accepted.", 409)
+		return
+	}
+	delete(seeks, s.Id)
+}
+
+// Accepts a seek: creates the game and returns the accepting player's seat.
+// The seeker receives theirs from /seek.
+func handleAcceptSeek(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", 405)
+		return
+	}
+	log.Print("POST /accept_seek")
+	var request struct {
+		Seek string
+	}
+	if body, err := ioutil.ReadAll(r.Body); err != nil {
+		http.Error(w, "Internal Server Error", 500)
+		return
+	} else if err := json.Unmarshal(body, &request); err != nil {
+		http.Error(w, "Bad Request\n"+err.Error(), 400)
+		return
+	}
+	seeks_mutex.Lock()
+	defer seeks_mutex.Unlock()
+	s := seeks[request.Seek]
+	if s == nil || s.expired(time.Now()) {
+		http.Error(w, "Not Found", 404)
+		return
+	}
+	if s.seat != nil {
+		http.Error(w, "Conflict\nThe seek was already accepted.", 409)
+		return
+	}
+	if s.queued {
+		http.Error(w, "Not Found", 404)
+		return
+	}
+	seeker, accepter := startSeatedGame(s.Size, s.TimeControl, s.Color)
+	if seeker == nil {
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	s.seat = seeker
+	// Give the seeker time to pick up the seat if they are reconnecting.
+	s.seen = time.Now()
+	close(s.accepted)
+	writeJsonResponse(w, accepter)
+}
+
+// A game in progress, as listed in the lobby.
+type liveGame struct {
+	Game        string      `json:"game"`
+	Size        int         `json:"size"`
+	TimeControl TimeControl `json:"timeControl"`
+	Moves       int         `json:"moves"`
+	NextPlayer  int         `json:"nextPlayer"`
+	last_time   time.Time
+}
+
+// Returns the listed games in memory that are still in progress, most
+// recently moved first.
+func liveGames() []liveGame {
+	games_mutex.Lock()
+	listed := make([]*game, 0, len(games))
+	for _, g := range games {
+		listed = append(listed, g)
+	}
+	games_mutex.Unlock()
+
+	live := []liveGame{}
+	for _, g := range listed {
+		g.mutex.Lock()
+		if g.Listed && !g.over() {
+			live = append(live, liveGame{
+				Game:        g.id,
+				Size:        len(g.State.Fields),
+				TimeControl: g.TimeControl,
+				Moves:       g.version(),
+				NextPlayer:  g.State.NextPlayer(),
+				last_time:   g.LastTime})
+		}
+		g.mutex.Unlock()
+	}
+	sort.Slice(live, func(i, j int) bool {
+		if !live[i].last_time.Equal(live[j].last_time) {
+			return live[i].last_time.After(live[j].last_time)
+		}
+		return live[i].Game < live[j].Game
+	})
+	return live
+}
+
+// Lists the open seeks, oldest first, and the live games.
+func handleLobby(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", 405)
+		return
+	}
+	log.Print("GET /lobby")
+	seeks_mutex.Lock()
+	purgeSeeks(time.Now())
+	open := []*seek{}
+	for _, s := range seeks {
+		if s.seat == nil && !s.queued {
+			open = append(open, s)
+		}
+	}
+	seeks_mutex.Unlock()
+	// The exported fields of seeks don't change, so no lock is needed here.
+	sort.Slice(open, func(i, j int) bool { return open[i].Created.Before(open[j].Created) })
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJsonResponse(w, map[string]interface{}{
+		"seeks": open,
+		"games": liveGames()})
+}
@@ -0,0 +1,190 @@
+package server
+
+import "ayu"
+import "encoding/json"
+import "io/ioutil"
+import "log"
+import "net/http"
+import "sort"
+import "time"
+
+// Players in the matchmaking queue are paired with opponents whose rating
+// differs by at most their range, which starts at queue_initial_range and
+// grows by queue_range_step every queue_widen_interval they wait.
+const (
+	default_rating       = 1500
+	queue_initial_range  = 100
+	queue_range_step     = 50
+	queue_widen_interval = 10 * time.Second
+	queue_match_interval = time.Second // how often waiting players are paired
+)
+
+var matching_scheduled bool // whether matchQueue will run; guarded by seeks_mutex
+
+// Returns how far from its own the rating of a player's opponent may be.
+func (s *seek) ratingRange(now time.Time) int {
+	return queue_initial_range + queue_range_step*int(now.Sub(s.Created)/queue_widen_interval)
+}
+
+// Returns whether two players in the queue want the same kind of game, and
+// are close enough in rating.
+func (s *seek) matches(o *seek, now time.Time) bool {
+	if !s.any_size && !o.any_size && s.Size != o.Size {
+		return false
+	}
+	if !s.any_time_control && !o.any_time_control && s.TimeControl != o.TimeControl {
+		return false
+	}
+	if s.Color != "" && s.Color == o.Color {
+		return false
+	}
+	diff := abs(s.rating - o.rating)
+	return diff <= s.ratingRange(now) && diff <= o.ratingRange(now)
+}
+
+// Starts the game for two matched players and notifies both.  The seeks
+// mutex must be held.  Returns false if the game could not be created.
+func startMatch(s *seek, o *seek) bool {
+	size, tc, color := s.Size, s.TimeControl, s.Color
+	if s.any_size {
+		size = o.Size
+	}
+	if s.any_time_control {
+		tc = o.TimeControl
+	}
+	if color == "" {
+		color = map[string]string{"white": "black", "black": "white", "": ""}[o.Color]
+	}
+	first, second := startSeatedGame(size, tc, color)
+	if first == nil {
+		return false
+	}
+	s.seat, o.seat = first, second
+	// Give the players time to pick up their seats if they are reconnecting.
+	s.seen, o.seen = time.Now(), time.Now()
+	close(s.accepted)
+	close(o.accepted)
+	return true
+}
+
+// Pairs the players in the queue, longest waiting first, each with the
+// closest rated player that matches.  Keeps running while players are
+// waiting, as their rating ranges widen.
+func matchQueue() {
+	seeks_mutex.Lock()
+	defer seeks_mutex.Unlock()
+	now := time.Now()
+	purgeSeeks(now)
+	queue := []*seek{}
+	for _, s := range seeks {
+		if s.queued && s.seat == nil {
+			queue = append(queue, s)
+		}
+	}
+	sort.Slice(queue, func(i, j int) bool { return queue[i].Created.Before(queue[j].Created) })
+	for i, s := range queue {
+		if s.seat != nil {
+			continue
+		}
+		var best *seek
+		for _, o := range queue[i+1:] {
+			if o.seat == nil && s.matches(o, now) &&
+				(best == nil || abs(o.rating-s.rating) < abs(best.rating-s.rating)) {
+				best = o
+			}
+		}
+		if best != nil && startMatch(s, best) {
+			log.Printf("Matched ratings %d and %d in game %s", s.rating, best.rating, s.seat.Game)
+		}
+	}
+
+	matching_scheduled = false
+	for _, s := range queue {
+		if s.seat == nil {
+			scheduleMatching()
+			break
+		}
+	}
+}
+
+// Arranges for matchQueue to run soon.  The seeks mutex must be held.
+func scheduleMatching() {
+	if !matching_scheduled {
+		matching_scheduled = true
+		time.AfterFunc(queue_match_interval, matchQueue)
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Adds a player to the matchmaking queue.  The request gives the player's
+// rating and preferences: board size (0 for any), time control (omitted for
+// any) and colour.  The response is like that of posting a seek, and the
+// player waits for their game at /seek.
+//
+// The rating is whatever the client reports.  Games aren't tied to player
+// accounts, so the server has no results to derive or check ratings from,
+// and a player can misreport theirs to get weaker or stronger opponents.
+// Matching by rating is only a convenience for honest players.
+func handleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", 405)
+		return
+	}
+	log.Print("POST /queue")
+	var request struct {
+		Size        int
+		TimeControl *TimeControl
+		Color       string
+		Rating      *int
+	}
+	if body, err := ioutil.ReadAll(r.Body); err != nil {
+		http.Error(w, "Internal Server Error", 500)
+		return
+	} else if err := json.Unmarshal(body, &request); err != nil {
+		http.Error(w, "Bad Request\n"+err.Error(), 400)
+		return
+	}
+	s := &seek{
+		Id:       createRandomKey(),
+		Size:     request.Size,
+		Color:    request.Color,
+		Created:  time.Now(),
+		secret:   createRandomKey(),
+		accepted: make(chan struct{}),
+		queued:   true,
+		rating:   default_rating,
+		any_size: request.Size == 0}
+	s.seen = s.Created
+	if s.any_size {
+		s.Size = ayu.DefaultSize
+	} else if !ayu.IsValidSize(s.Size) {
+		http.Error(w, "Bad Request\nInvalid board size.", 400)
+		return
+	}
+	if request.TimeControl == nil {
+		s.any_time_control = true
+	} else if err := request.TimeControl.validate(); err != nil {
+		http.Error(w, "Bad Request\n"+err.Error(), 400)
+		return
+	} else {
+		s.TimeControl = *request.TimeControl
+	}
+	if s.Color != "" && s.Color != "white" && s.Color != "black" {
+		http.Error(w, "Bad Request\nColour must be white, black or empty.", 400)
+		return
+	}
+	if request.Rating != nil {
+		s.rating = *request.Rating
+	}
+	seeks_mutex.Lock()
+	seeks[s.Id] = s
+	scheduleMatching()
+	seeks_mutex.Unlock()
+	writeJsonResponse(w, map[string]interface{}{"seek": s.Id, "secret": s.secret})
+}
@@ -5,6 +5,7 @@ import "container/list"
 import "crypto/rand"
 import "encoding/json"
 import "encoding/hex"
+import "errors"
 import "io/ioutil"
 import "log"
 import "net/http"
@@ -29,17 +30,237 @@ type SaveLoader interface {
 	Loader
 }
 
+var color_names = [2]string{"White", "Black"}
+
+// How a game ended.
+type gameResult struct {
+	Winner  int    `json:"winner"` // +1 (white), -1 (black) or 0 (draw)
+	Reason  string `json:"reason"`
+	Aborted bool   `json:"aborted,omitempty"`
+}
+
 type game struct {
-	State    *ayu.State
-	TimeUsed [2]time.Duration
-	LastTime time.Time
-	Keys     [2]string
-	waiting  *list.List
-	mutex    sync.Mutex // must be held while accessing fields above
+	State       *ayu.State
+	TimeUsed    [2]time.Duration
+	LastTime    time.Time
+	Keys        [2]string
+	TimeControl TimeControl
+	TimeLeft    [2]time.Duration // as of LastTime
+	PeriodsLeft [2]int           // byo-yomi only
+	Result      *gameResult      // set if the game ended other than on the board
+	DrawOffer   int              // player offering a draw: +1 (white), -1 (black) or 0
+	Takeback    int              // player requesting a takeback: +1 (white), -1 (black) or 0
+	Revision    int              // incremented on every change
+
+	Chat          []chatMessage        // oldest first
+	SpectatorChat bool                 // whether spectators may chat
+	Listed        bool                 // shown in the lobby's list of live games
+	Difficulty    string               // for computer players, one of difficulties or ""
+	chat_times    map[string]time.Time // last chat message per sender
+
+	id         string
+	flag_timer *time.Timer
+	waiting    *list.List
+	mutex      sync.Mutex // must be held while accessing fields above
 }
 
 func (g *game) version() int { return len(g.State.History) }
 
+// Returns how the game ended, or nil if it is still in progress.
+func (g *game) result() *gameResult {
+	if g.Result != nil {
+		return g.Result
+	}
+	if g.State.Over() {
+		return &gameResult{Winner: g.State.NextPlayer(), Reason: color_names[g.State.Next()] + " has no moves left"}
+	}
+	return nil
+}
+
+func (g *game) over() bool { return g.result() != nil }
+
+// Returns the game state as sent to clients.  Messages on the player chat
+// channel are only included for players.  The game mutex must be held.
+func (g *game) stateResponse(player bool) map[string]interface{} {
+	time_used := [2]float64{
+		g.TimeUsed[0].Seconds(),
+		g.TimeUsed[1].Seconds()}
+	if !g.LastTime.IsZero() && !g.over() {
+		time_used[g.State.Next()] +=
+			time.Now().Sub(g.LastTime).Seconds()
+	}
+	response := map[string]interface{}{
+		"nextPlayer": g.State.NextPlayer(),
+		"size":       len(g.State.Fields),
+		"fields":     g.State.Fields,
+		"history":    g.State.History,
+		"timeUsed":   time_used,
+		"revision":   g.Revision}
+	if g.DrawOffer != 0 {
+		response["drawOffer"] = g.DrawOffer
+	}
+	if g.Takeback != 0 {
+		response["takeback"] = g.Takeback
+	}
+	if g.TimeControl.Type != time_control_none {
+		left, periods := g.timeLeft(time.Now())
+		response["timeControl"] = g.TimeControl
+		response["timeLeft"] = [2]float64{left[0].Seconds(), left[1].Seconds()}
+		if g.TimeControl.Type == time_control_byoyomi {
+			response["periodsLeft"] = periods
+		}
+	}
+	if result := g.result(); result != nil {
+		response["result"] = result
+	}
+	var chat []chatMessage
+	for _, message := range g.Chat {
+		if player || message.Channel != chat_players {
+			chat = append(chat, message)
+		}
+	}
+	if len(chat) > 0 {
+		response["chat"] = chat
+	}
+	if g.SpectatorChat {
+		response["spectatorChat"] = true
+	}
+	if g.Difficulty != "" {
+		response["difficulty"] = g.Difficulty
+	}
+	return response
+}
+
+// Wakes up all goroutines waiting for updates.  The game mutex must be held.
+func (g *game) notifyWaiting() {
+	for {
+		elem := g.waiting.Front()
+		if elem == nil {
+			break
+		}
+		g.waiting.Remove(elem).(chan bool) <- true
+	}
+}
+
+// How long writing an update to a client may take before the client is
+// considered gone.
+const write_timeout = time.Minute
+
+// Calls send whenever the game revision differs from the revision last
+// sent, starting with the given revision, and idle after every poll_delay
+// without changes, until either returns an error or done is closed.  Send
+// gets the revision with the encoded state (for a player, if player is
+// set) and the result at that revision.
+// It is called without the game mutex held, so a slow client doesn't hold
+// up the game.
+func (g *game) followUpdates(revision int, player bool, done <-chan struct{}, send func(revision int, state []byte, result *gameResult) error, idle func() error) {
+	update_ch := make(chan bool, 1)
+	for {
+		g.mutex.Lock()
+		changed := g.Revision != revision
+		var state []byte
+		var result *gameResult
+		if changed {
+			revision = g.Revision
+			var err error
+			if state, err = json.Marshal(g.stateResponse(player)); err != nil {
+				log.Fatalln(err)
+			}
+			if r := g.result(); r != nil {
+				copied := *r
+				result = &copied
+			}
+		}
+		// Start waiting before sending, so that changes made meanwhile
+		// aren't missed.
+		elem := g.waiting.PushBack(update_ch)
+		g.mutex.Unlock()
+		var err error
+		if changed {
+			err = send(revision, state, result)
+		}
+		if err == nil {
+			select {
+			case <-update_ch:
+			case <-done:
+				err = errors.New("done")
+			case <-time.After(poll_delay):
+				err = idle()
+			}
+		}
+		g.mutex.Lock()
+		g.waiting.Remove(elem)
+		g.mutex.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Records a change to the game: saves it, wakes up waiting clients and
+// reschedules the flag fall.  The game mutex must be held.
+func (g *game) changed(db Saver) {
+	g.Revision++
+	g.scheduleFlagFall(db)
+	if db != nil {
+		if encoded, err := json.Marshal(g); err != nil {
+			log.Fatalln(err)
+		} else if err := db.Save("Game", []byte(g.id), encoded); err != nil {
+			log.Printf("Failed to save game %s: %s", g.id, err)
+		}
+	}
+	g.notifyWaiting()
+}
+
+// Ends the game with the given winner.  The game mutex must be held.
+func (g *game) end(db Saver, winner int, reason string) {
+	g.Result = &gameResult{Winner: winner, Reason: reason}
+	g.DrawOffer = 0
+	g.Takeback = 0
+	g.changed(db)
+}
+
+// Plays a move on behalf of the player with the given key, saves the game
+// and notifies waiting clients.  The game mutex must be held.  Returns an
+// HTTP status code and message if the move is rejected.
+func (g *game) applyMove(db Saver, version int, key string, move ayu.Move) (int, string) {
+	if g.over() {
+		return 403, "Game Over"
+	}
+	if version != g.version() {
+		return 409, "Wrong Version"
+	}
+	player := g.State.Next()
+	if key != g.Keys[player] {
+		return 403, "Forbidden"
+	}
+	now := time.Now()
+	if g.checkFlag(db, now) {
+		return 403, "Out of time"
+	}
+	if !g.State.Execute(move) {
+		return 403, "Illegal move"
+	}
+
+	// Moving instead of accepting declines a draw offered by the opponent.
+	if g.DrawOffer == g.State.NextPlayer() {
+		g.DrawOffer = 0
+	}
+	// Any move withdraws or declines a takeback request.
+	g.Takeback = 0
+
+	// Update clock of last player.  The clocks start after the first move.
+	if !g.LastTime.IsZero() {
+		spent := now.Sub(g.LastTime)
+		g.TimeUsed[player] += spent
+		g.pressClock(player, spent)
+	}
+	g.LastTime = now
+
+	g.changed(db)
+	return 200, ""
+}
+
 type Client struct {
 	output chan<- string
 }
@@ -59,6 +280,10 @@ func getGame(r *http.Request, id string) *game {
 	res := games[id]
 	games_mutex.Unlock()
 	if res != nil {
+		// Also end the game if the flag fell without the timer noticing.
+		res.mutex.Lock()
+		res.checkFlag(getDatabase(r), time.Now())
+		res.mutex.Unlock()
 		return res
 	}
 	// Game not in memory. Try to read it from database instead.
@@ -75,6 +300,7 @@ func getGame(r *http.Request, id string) *game {
 			log.Printf("Could not unmarshal game %s: %s. (Encoded: '%s')",
 				id, err, encoded)
 		} else {
+			game.id = id
 			game.waiting = list.New()
 			res = &game
 			games_mutex.Lock()
@@ -85,6 +311,11 @@ func getGame(r *http.Request, id string) *game {
 				games[id] = res
 			}
 			games_mutex.Unlock()
+			res.mutex.Lock()
+			if !res.checkFlag(db, time.Now()) {
+				res.scheduleFlagFall(db)
+			}
+			res.mutex.Unlock()
 		}
 		return res
 	}
@@ -112,13 +343,20 @@ func handlePoll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Wait for game to reach requested version.
+	// Wait for game to reach requested version, or to end.  Also return if
+	// moves were taken back, so the client's last version is gone.  If a
+	// revision is given, also return after any other change, like a draw
+	// offer.
 	version, _ := strconv.Atoi(r.FormValue("version"))
+	revision := -1
+	if r.FormValue("revision") != "" {
+		revision, _ = strconv.Atoi(r.FormValue("revision"))
+	}
 	game.mutex.Lock()
 	timeout_ch := time.After(poll_delay)
 	update_ch := make(chan bool, 1)
 	timed_out := false
-	for game.version() < version && !timed_out {
+	for game.version() < version && game.version() >= version-1 && !game.over() && (revision < 0 || game.Revision <= revision) && !timed_out {
 		elem := game.waiting.PushBack(update_ch)
 		game.mutex.Unlock()
 		select {
@@ -137,19 +375,7 @@ func handlePoll(w http.ResponseWriter, r *http.Request) {
 	if timed_out {
 		w.WriteHeader(204) // HTTP 204 "No Content"
 	} else {
-		time_used := [2]float64{
-			game.TimeUsed[0].Seconds(),
-			game.TimeUsed[1].Seconds()}
-		if !game.LastTime.IsZero() {
-			time_used[game.State.Next()] +=
-				time.Now().Sub(game.LastTime).Seconds()
-		}
-		writeJsonResponse(w, map[string]interface{}{
-			"nextPlayer": game.State.NextPlayer(),
-			"size":       len(game.State.Fields),
-			"fields":     game.State.Fields,
-			"history":    game.State.History,
-			"timeUsed":   time_used})
+		writeJsonResponse(w, game.stateResponse(game.playerWithKey(r.FormValue("key")) >= 0))
 	}
 	game.mutex.Unlock()
 }
@@ -162,6 +388,42 @@ func createRandomKey() string {
 	return hex.EncodeToString(buf[:])
 }
 
+// Difficulty levels that can be requested for computer players of a game.
+// The server only passes them on to clients, which configure their player
+// programs accordingly (see the Difficulty option of cmd/ayu-engine).
+var difficulties = []string{"beginner", "easy", "medium", "hard", "expert"}
+
+func isDifficulty(name string) bool {
+	for _, d := range difficulties {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Creates a new game with random keys and adds it to the games in memory.
+// Returns nil if the game id is already taken.
+func createGame(size int, tc TimeControl, spectator_chat bool, listed bool, difficulty string) *game {
+	id := createRandomKey()
+	games_mutex.Lock()
+	defer games_mutex.Unlock()
+	if games[id] != nil {
+		return nil
+	}
+	games[id] = &game{
+		State:         ayu.CreateState(size),
+		Keys:          [2]string{createRandomKey(), createRandomKey()},
+		TimeControl:   tc,
+		SpectatorChat: spectator_chat,
+		Listed:        listed,
+		Difficulty:    difficulty,
+		id:            id,
+		waiting:       list.New()}
+	games[id].startClocks()
+	return games[id]
+}
+
 func handleCreate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method Not Allowed", 405)
@@ -169,7 +431,11 @@ func handleCreate(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Print("POST /create")
 	var create struct {
-		Size int
+		Size          int
+		TimeControl   TimeControl
+		SpectatorChat bool
+		Listed        bool
+		Difficulty    string
 	}
 	if body, err := ioutil.ReadAll(r.Body); err != nil {
 		http.Error(w, "Internal Server Error", 500)
@@ -185,21 +451,23 @@ func handleCreate(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad Request\nInvalid board size.", 400)
 		return
 	}
-	id := createRandomKey()
-	games_mutex.Lock()
-	defer games_mutex.Unlock()
-	if games[id] != nil {
+	if err := create.TimeControl.validate(); err != nil {
+		http.Error(w, "Bad Request\n"+err.Error(), 400)
+		return
+	}
+	if create.Difficulty != "" && !isDifficulty(create.Difficulty) {
+		http.Error(w, "Bad Request\nUnknown difficulty.", 400)
+		return
+	}
+	g := createGame(create.Size, create.TimeControl, create.SpectatorChat, create.Listed, create.Difficulty)
+	if g == nil {
 		// This should be extremely improbable!
 		http.Error(w, "Internal Server Error", 500)
 		return
 	}
-	games[id] = &game{ayu.CreateState(create.Size),
-		[2]time.Duration{0, 0}, time.Time{},
-		[2]string{createRandomKey(), createRandomKey()},
-		list.New(), sync.Mutex{}}
 	writeJsonResponse(w, map[string]interface{}{
-		"game": id,
-		"keys": games[id].Keys,
+		"game": g.id,
+		"keys": g.Keys,
 		"size": create.Size,
 	})
 }
@@ -231,49 +499,27 @@ func handleUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 	game.mutex.Lock()
 	defer game.mutex.Unlock()
-	if update.Version != game.version() {
-		http.Error(w, "Wrong Version", 409)
-		return
-	}
-	player := game.State.Next()
-	if update.Key != game.Keys[player] {
-		http.Error(w, "Forbidden", 403)
-		return
-	}
-	if !game.State.Execute(update.Move) {
-		http.Error(w, "Illegal move", 403)
-		return
-	}
-
-	// Update time used by last player.
-	now := time.Now()
-	if !game.LastTime.IsZero() {
-		game.TimeUsed[player] += now.Sub(game.LastTime)
-	}
-	game.LastTime = now
-
-	if db := getDatabase(r); db != nil {
-		if encoded, err := json.Marshal(game); err != nil {
-			log.Fatalln(err)
-		} else if err := db.Save("Game", []byte(update.Game), encoded); err != nil {
-			log.Printf("Failed to save game %s: %s", update.Game, err)
-		}
-	}
-
-	// Notify goroutines waiting for updates.
-	for {
-		elem := game.waiting.Front()
-		if elem == nil {
-			break
-		}
-		game.waiting.Remove(elem).(chan bool) <- true
+	if status, message := game.applyMove(getDatabase(r), update.Version, update.Key, update.Move); status != 200 {
+		http.Error(w, message, status)
 	}
 }
 
 func Setup(static_data_dir string, poll_delay_seconds int, db_getter func(*http.Request) SaveLoader) {
 	http.HandleFunc("/poll", handlePoll)
+	http.HandleFunc("/chat", handleChat)
 	http.HandleFunc("/create", handleCreate)
 	http.HandleFunc("/update", handleUpdate)
+	http.HandleFunc("/socket", handleSocket)
+	http.HandleFunc("/events", handleEvents)
+	http.HandleFunc("/lobby", handleLobby)
+	http.HandleFunc("/seek", handleSeek)
+	http.HandleFunc("/cancel_seek", handleCancelSeek)
+	http.HandleFunc("/accept_seek", handleAcceptSeek)
+	http.HandleFunc("/queue", handleQueue)
+	for _, action := range []string{action_resign, action_offer_draw, action_accept_draw, action_decline_draw, action_abort,
+		action_takeback, action_accept_back, action_decline_back} {
+		http.HandleFunc("/"+action, actionHandler(action))
+	}
 	if static_data_dir != "" {
 		if info, err := os.Stat(static_data_dir); err != nil {
 			log.Fatalln(err)
@@ -5,35 +5,49 @@ CLOCK = {};
 	var player = 0
 	var white_millis = 0
 	var black_millis = 0
+	var counting_down = false  // showing time left instead of time used
+	var periods = null  // byo-yomi periods left for white and black
 	var cur_time_millis = new Date().getTime()
 
-	var updateTimeText = function(elem_id, millis) {
+	var updateTimeText = function(elem_id, millis, periods_left) {
 		var seconds = parseInt(millis/1000)
 		var minutes = parseInt(seconds/60)
 		seconds %= 60
 		if (seconds < 10) seconds = '0' + seconds
+		var text = minutes + ':' + seconds
+		if (periods_left !== null) text += ' (' + periods_left + ')'
 		var elem = document.getElementById(elem_id)
 		while (elem.firstChild) elem.removeChild(elem.firstChild)
-		elem.appendChild(document.createTextNode(minutes + ':' + seconds))
+		elem.appendChild(document.createTextNode(text))
 	}
 
 	var updateTime = function() {
 		var new_time_millis = new Date().getTime()
 		var millis = new_time_millis - cur_time_millis
 		cur_time_millis = new_time_millis
-		if (player == +1) white_millis += millis
-		if (player == -1) black_millis += millis
-		updateTimeText('whiteTime', white_millis)
-		updateTimeText('blackTime', black_millis)
+		if (counting_down) millis = -millis
+		if (player == +1) white_millis = Math.max(0, white_millis + millis)
+		if (player == -1) black_millis = Math.max(0, black_millis + millis)
+		updateTimeText('whiteTime', white_millis, periods && periods[0])
+		updateTimeText('blackTime', black_millis, periods && periods[1])
 	}
 
 	setInterval(updateTime, 1000)
 
+	// Sets the player whose clock is running, or 0 to stop the clocks.
 	CLOCK.setPlayer = function(p) {
 		updateTime()
 		player = p
 	}
 	CLOCK.setTimeUsed = function(white_seconds, black_seconds) {
+		counting_down = false
+		white_millis = parseInt(1000*white_seconds)
+		black_millis = parseInt(1000*black_seconds)
+		updateTime()
+	}
+	CLOCK.setTimeLeft = function(white_seconds, black_seconds, periods_left) {
+		counting_down = true
+		periods = periods_left || null
 		white_millis = parseInt(1000*white_seconds)
 		black_millis = parseInt(1000*black_seconds)
 		updateTime()
@@ -14,7 +14,45 @@
 		}
 	}
 
-	var createGame = function(size) {
+	// Rows of the form used by each type of time control.
+	var time_control_rows = {
+		'': [],
+		'sudden death': ['initialRow'],
+		'fischer': ['initialRow', 'incrementRow'],
+		'bronstein': ['initialRow', 'delayRow'],
+		'byoyomi': ['initialRow', 'periodsRow'],
+		'correspondence': ['daysRow'],
+	}
+
+	var showTimeControlRows = function() {
+		var rows = time_control_rows[document.getElementById('timeControl').value]
+		var all = ['initialRow', 'incrementRow', 'delayRow', 'periodsRow', 'daysRow']
+		for (var i = 0; i < all.length; ++i) {
+			document.getElementById(all[i]).style.display =
+				rows.indexOf(all[i]) >= 0 ? '' : 'none'
+		}
+	}
+
+	var getNumber = function(id) {
+		return parseFloat(document.getElementById(id).value) || 0
+	}
+
+	var getTimeControl = function() {
+		var type = document.getElementById('timeControl').value
+		var rows = time_control_rows[type]
+		var tc = {'type': type}
+		if (rows.indexOf('initialRow') >= 0) tc.initial = 60*getNumber('initialMinutes')
+		if (rows.indexOf('incrementRow') >= 0) tc.increment = getNumber('incrementSeconds')
+		if (rows.indexOf('delayRow') >= 0) tc.delay = getNumber('delaySeconds')
+		if (rows.indexOf('periodsRow') >= 0) {
+			tc.periods = parseInt(getNumber('periods'))
+			tc.period = getNumber('periodSeconds')
+		}
+		if (rows.indexOf('daysRow') >= 0) tc.days = parseInt(getNumber('days'))
+		return tc
+	}
+
+	var createGame = function(size, time_control, spectator_chat, listed, difficulty) {
 		console.log("Creating game with board size " + size)
 		var req = new XMLHttpRequest()
 		req.onreadystatechange = function(){
@@ -28,11 +66,133 @@
 			}
 		}
 		req.open('POST', 'create', true)
-		req.send(JSON.stringify({"size": size}))
+		req.send(JSON.stringify({"size": size, "timeControl": time_control, "spectatorChat": spectator_chat, "listed": listed, "difficulty": difficulty}))
 	}
 
 	document.getElementById('createGameForm').onsubmit = function() {
-		createGame(parseInt(document.getElementById('boardSize').value))
+		createGame(parseInt(document.getElementById('boardSize').value), getTimeControl(),
+			document.getElementById('spectatorChat').checked,
+			document.getElementById('listed').checked,
+			document.getElementById('difficulty').value)
 		return false
 	}
+
+	document.getElementById('timeControl').onchange = showTimeControlRows
+	showTimeControlRows()
+
+	// Sends a JSON request and calls done with the parsed response (or null
+	// if there was none).
+	var request = function(method, url, data, done) {
+		var req = new XMLHttpRequest()
+		req.onreadystatechange = function(){
+			if (req.readyState == 4) {
+				if (req.status >= 400) {
+					alert("Request failed!\n" + req.responseText)
+					return
+				}
+				done(req.responseText ? JSON.parse(req.responseText) : null)
+			}
+		}
+		req.open(method, url, true)
+		req.send(data === null ? null : JSON.stringify(data))
+	}
+
+	var gameLink = function(seat) {
+		return 'game.html#game=' + encodeURIComponent(seat.game) +
+			'&' + seat.color + '=' + encodeURIComponent(seat.key) + '&size=' + seat.size
+	}
+
+	var describeTimeControl = function(tc) {
+		if (!tc.type) return 'no time control'
+		if (tc.type == 'correspondence') return tc.days + ' days per move'
+		var text = tc.type + ' ' + (tc.initial/60) + ' min'
+		if (tc.increment) text += ' +' + tc.increment + 's'
+		if (tc.delay) text += ' +' + tc.delay + 's delay'
+		if (tc.periods) text += ' +' + tc.periods + 'x' + tc.period + 's'
+		return text
+	}
+
+	var addRow = function(table, cells, button_text, onclick) {
+		var row = document.createElement('tr')
+		for (var i = 0; i < cells.length; ++i) {
+			var cell = document.createElement('td')
+			cell.appendChild(document.createTextNode(cells[i]))
+			row.appendChild(cell)
+		}
+		var cell = document.createElement('td')
+		var button = document.createElement('button')
+		button.appendChild(document.createTextNode(button_text))
+		button.onclick = onclick
+		cell.appendChild(button)
+		row.appendChild(cell)
+		table.appendChild(row)
+	}
+
+	var my_seek = null  // {seek, secret} while we are seeking
+
+	var showLobby = function(lobby) {
+		var seeks_elem = document.getElementById('seeks')
+		while (seeks_elem.firstChild) seeks_elem.removeChild(seeks_elem.firstChild)
+		lobby.seeks.forEach(function(seek) {
+			if (my_seek && seek.id == my_seek.seek) return
+			var color = {'': 'either colour', 'white': 'seeker plays white', 'black': 'seeker plays black'}[seek.color]
+			addRow(seeks_elem, [seek.size + 'x' + seek.size, describeTimeControl(seek.timeControl), color], 'Play', function() {
+				request('POST', 'accept_seek', {'seek': seek.id}, function(seat) {
+					location.href = gameLink(seat)
+				})
+			})
+		})
+		var games_elem = document.getElementById('liveGames')
+		while (games_elem.firstChild) games_elem.removeChild(games_elem.firstChild)
+		lobby.games.forEach(function(game) {
+			addRow(games_elem, [game.size + 'x' + game.size, describeTimeControl(game.timeControl), game.moves + ' moves'], 'Watch', function() {
+				location.href = 'game.html#game=' + encodeURIComponent(game.game) + '&size=' + game.size
+			})
+		})
+	}
+
+	var refreshLobby = function() {
+		request('GET', 'lobby', null, function(lobby) {
+			showLobby(lobby)
+			setTimeout(refreshLobby, 5000)
+		})
+	}
+
+	// Waits until our seek is accepted, then goes to the game.
+	var waitForSeek = function() {
+		if (!my_seek) return
+		request('GET', 'seek?seek=' + encodeURIComponent(my_seek.seek) + '&secret=' + encodeURIComponent(my_seek.secret), null, function(seat) {
+			if (seat) {
+				location.href = gameLink(seat)
+			} else {
+				waitForSeek()
+			}
+		})
+	}
+
+	var showSeeking = function(seeking) {
+		document.getElementById('seekButton').style.display = seeking ? 'none' : ''
+		document.getElementById('seeking').style.display = seeking ? '' : 'none'
+	}
+
+	document.getElementById('seekButton').onclick = function() {
+		var seek = {
+			'size': parseInt(document.getElementById('boardSize').value),
+			'timeControl': getTimeControl(),
+			'color': document.getElementById('seekColor').value}
+		request('POST', 'seek', seek, function(res) {
+			my_seek = res
+			showSeeking(true)
+			waitForSeek()
+		})
+	}
+
+	document.getElementById('cancelSeekButton').onclick = function() {
+		request('POST', 'cancel_seek', my_seek, function() {
+			my_seek = null
+			showSeeking(false)
+		})
+	}
+
+	refreshLobby()
 })()
@@ -2,6 +2,26 @@
 	'use strict'
 	var state = null
 	var my_last_version = null
+	var shown_history = null  // moves shown on the board, as JSON
+	var socket = null  // open WebSocket, if any
+
+	var sendMove = function(update) {
+		if (socket) {
+			socket.send(JSON.stringify(update))
+			return
+		}
+		var req = new XMLHttpRequest()
+		req.onreadystatechange = function(){
+			if (req.readyState == 4) {
+				if (req.status != 200) {
+					alert("Update request failed!\n" + req.responseText)
+				}
+			}
+		}
+		req.open('POST', 'update', true)
+		req.setRequestHeader("Content-type", "application/json")
+		req.send(JSON.stringify(update))
+	}
 
 	var getPlayerKey = function(player) {
 		if (player == +1) return getParameter('white')
@@ -9,8 +29,136 @@
 		return null
 	}
 
+	// Returns the query parameter with one of our player keys, if any, so
+	// that the server includes the player chat in the game state.
+	var keyParameter = function() {
+		var key = getParameter('white') || getParameter('black')
+		return key ? '&key=' + encodeURIComponent(key) : ''
+	}
+
+	// Returns the player we act for: the player to move if we have both keys.
+	var getMyPlayer = function() {
+		if (getPlayerKey(state.nextPlayer)) return state.nextPlayer
+		if (getPlayerKey(-state.nextPlayer)) return -state.nextPlayer
+		return 0
+	}
+
+	// Sends an action (like 'resign') on behalf of our player.
+	var sendAction = function(action) {
+		var update = {'game': getParameter('game'), 'key': getPlayerKey(getMyPlayer()), 'action': action}
+		if (socket) {
+			socket.send(JSON.stringify(update))
+			return
+		}
+		var req = new XMLHttpRequest()
+		req.onreadystatechange = function(){
+			if (req.readyState == 4) {
+				if (req.status != 200) {
+					alert("Request failed!\n" + req.responseText)
+				}
+			}
+		}
+		req.open('POST', action, true)
+		req.setRequestHeader("Content-type", "application/json")
+		req.send(JSON.stringify(update))
+	}
+
+	var buttons = {
+		'resignButton': 'resign',
+		'offerDrawButton': 'offer_draw',
+		'acceptDrawButton': 'accept_draw',
+		'declineDrawButton': 'decline_draw',
+		'abortButton': 'abort',
+		'takebackButton': 'takeback',
+		'acceptTakebackButton': 'accept_takeback',
+		'declineTakebackButton': 'decline_takeback'}
+	for (var id in buttons) {
+		document.getElementById(id).onclick = (function(action) {
+			return function() {
+				if (action != 'resign' || confirm('Really resign?')) sendAction(action)
+			}
+		})(buttons[id])
+	}
+
+	var showElem = function(id, show) {
+		document.getElementById(id).style.display = show ? '' : 'none'
+	}
+
+	var updateActions = function() {
+		var me = getMyPlayer()
+		var offered_to_me = state.drawOffer && state.drawOffer == -me
+		showElem('actions', !state.result && me)
+		showElem('offerDrawButton', !offered_to_me && state.drawOffer != me)
+		showElem('acceptDrawButton', offered_to_me)
+		showElem('declineDrawButton', offered_to_me)
+		showElem('abortButton', state.history.length < 2)
+		// Black's first move is the second move of the game.
+		var requested_of_me = state.takeback && state.takeback == -me
+		showElem('takebackButton', !state.takeback && state.history.length >= (me > 0 ? 1 : 2))
+		showElem('acceptTakebackButton', requested_of_me)
+		showElem('declineTakebackButton', requested_of_me)
+		setNotice('drawOffer', state.drawOffer, ' offers a draw.')
+		setNotice('takebackRequest', state.takeback, ' requests a takeback.')
+	}
+
+	// Shows which player (if any) made an offer or request.
+	var setNotice = function(id, player, text) {
+		var elem = document.getElementById(id)
+		while (elem.firstChild) elem.removeChild(elem.firstChild)
+		if (player && !state.result) {
+			elem.appendChild(document.createTextNode((player > 0 ? 'White' : 'Black') + text))
+		}
+		showElem(id, player && !state.result)
+	}
+
+	var updateChat = function() {
+		var messages = state.chat || []
+		var list_elem = document.getElementById('chatMessages')
+		while (list_elem.firstChild) list_elem.removeChild(list_elem.firstChild)
+		for (var i = 0; i < messages.length; ++i) {
+			var item = document.createElement('li')
+			item.className = messages[i].channel
+			item.appendChild(document.createTextNode(messages[i].from + ': ' + messages[i].text))
+			list_elem.appendChild(item)
+		}
+		list_elem.scrollTop = list_elem.scrollHeight
+		var me = getMyPlayer()
+		document.getElementById('chat').style.display = (me || state.spectatorChat) ? '' : 'none'
+		document.getElementById('chatName').style.display = me ? 'none' : ''
+	}
+
+	// Players chat with each other; everyone else uses the spectator channel.
+	document.getElementById('chatForm').onsubmit = function() {
+		var text_elem = document.getElementById('chatText')
+		var me = getMyPlayer()
+		var message = {
+			'game': getParameter('game'),
+			'channel': me ? 'players' : 'spectators',
+			'key': getPlayerKey(me),
+			'name': document.getElementById('chatName').value,
+			'chat': text_elem.value}
+		text_elem.value = ''
+		if (socket) {
+			socket.send(JSON.stringify(message))
+			return false
+		}
+		message.text = message.chat
+		var req = new XMLHttpRequest()
+		req.onreadystatechange = function(){
+			if (req.readyState == 4) {
+				if (req.status != 200) {
+					alert("Chat request failed!\n" + req.responseText)
+				}
+			}
+		}
+		req.open('POST', 'chat', true)
+		req.setRequestHeader("Content-type", "application/json")
+		req.send(JSON.stringify(message))
+		return false
+	}
+
 	BOARD_ELEM.addEventListener('field-click', function(event) {
-		if (!state || !getPlayerKey(state.nextPlayer)) return
+		if (!state || state.result || !getPlayerKey(state.nextPlayer)) return
 		if (HISTORY_ELEM.getSelected() != state.history.length - 1) {
 			selectMove(state.history.length - 1)
 		}
@@ -24,21 +172,11 @@
 				BOARD_ELEM.setSelected(row, col)
 			}
 		} else if (player == 0 && BOARD_ELEM.getSelected()) {
-			var req = new XMLHttpRequest()
-			req.onreadystatechange = function(){
-				if (req.readyState == 4) {
-					if (req.status != 200) {
-						alert("Update request failed!\n" + req.responseText)
-					}
-				}
-			}
-			req.open('POST', 'update', true)
-			req.setRequestHeader("Content-type", "application/json")
-			req.send(JSON.stringify({
+			sendMove({
 				'game': getParameter('game'),
 				'version': state.history.length,
 				'key': getPlayerKey(state.nextPlayer),
-				'move': [BOARD_ELEM.getSelected(), [row,col]]}))
+				'move': [BOARD_ELEM.getSelected(), [row,col]]})
 			BOARD_ELEM.clearSelected()
 			my_last_version = state.history.length + 1
 		}
@@ -68,17 +206,34 @@
 	}
 
 	var update = function() {  // called whenever the game state changes
-		BOARD_ELEM.clearSelected()
-		BOARD_ELEM.setFields(state.fields)
+		var playing = !state.result
 		document.getElementById('whiteToMove').style.display =
-			(state.nextPlayer == +1) ? '' : 'none'
+			(playing && state.nextPlayer == +1) ? '' : 'none'
 		document.getElementById('blackToMove').style.display =
-			(state.nextPlayer == -1) ? '' : 'none'
+			(playing && state.nextPlayer == -1) ? '' : 'none'
 		document.getElementById('yourTurn').style.display =
-			getPlayerKey(state.nextPlayer) ? '' : 'none'
+			(playing && getPlayerKey(state.nextPlayer)) ? '' : 'none'
+		var result_elem = document.getElementById('result')
+		while (result_elem.firstChild) result_elem.removeChild(result_elem.firstChild)
+		updateActions()
+		if (state.result) {
+			var winner = {'1': 'White wins', '-1': 'Black wins', '0': 'Draw'}
+			if (state.result.aborted) winner['0'] = 'Aborted'
+			result_elem.appendChild(document.createTextNode(
+				winner[state.result.winner] + ': ' + state.result.reason + '.'))
+		}
 
-		HISTORY_ELEM.reset(state.history)
-		selectMove(state.history.length - 1)
+		updateChat()
+
+		// Only redraw the board if the moves changed, so that chat messages
+		// and offers don't clear the selected piece.
+		var history_json = JSON.stringify(state.history)
+		if (history_json != shown_history) {
+			shown_history = history_json
+			BOARD_ELEM.setFields(state.fields)
+			HISTORY_ELEM.reset(state.history)
+			selectMove(state.history.length - 1)
+		}
 
 		if (my_last_version === null) {
 			my_last_version = state.history.length
@@ -87,8 +242,11 @@
 			document.getElementById('turnNotification').play()
 		}
 
-		CLOCK.setPlayer(state.nextPlayer)
-		if (state.timeUsed) {
+		// Clocks start running after the first move.
+		CLOCK.setPlayer(playing && state.history.length > 0 ? state.nextPlayer : 0)
+		if (state.timeLeft) {
+			CLOCK.setTimeLeft(state.timeLeft[0], state.timeLeft[1], state.periodsLeft)
+		} else if (state.timeUsed) {
 			CLOCK.setTimeUsed(state.timeUsed[0], state.timeUsed[1])
 		}
 	}
@@ -119,10 +277,61 @@
 				}
 			}
 		}
-		req.open('GET', 'poll?game=' + encodeURIComponent(game) + '&version=' + version, true)
+		var url = 'poll?game=' + encodeURIComponent(game) + '&version=' + version + keyParameter()
+		if (state && state.revision !== undefined) url += '&revision=' + state.revision
+		req.open('GET', url, true)
 		req.send()
 	}
 
-	pollState(getParameter('game'), 0)
+	// Receives updates as server-sent events, falling back to polling if
+	// the browser or server doesn't support them.  The browser reconnects
+	// by itself after transient errors, resuming from the last version.
+	var listenForEvents = function(game) {
+		if (!window.EventSource) {
+			pollState(game, state ? state.history.length + 1 : 0)
+			return
+		}
+		var events = new EventSource('events?game=' + encodeURIComponent(game) + keyParameter())
+		events.onmessage = function(event) {
+			state = JSON.parse(event.data)
+			update()
+		}
+		events.onerror = function() {
+			if (events.readyState == EventSource.CLOSED) {
+				pollState(game, state ? state.history.length + 1 : 0)
+			}
+		}
+	}
+
+	// Receives updates over a WebSocket, falling back to server-sent events
+	// if the browser or server doesn't support it or the connection is lost.
+	var connect = function(game) {
+		if (!window.WebSocket) {
+			listenForEvents(game)
+			return
+		}
+		var url = (location.protocol == 'https:' ? 'wss:' : 'ws:') + '//' +
+			location.host + location.pathname.replace(/[^\/]*$/, '') +
+			'socket?game=' + encodeURIComponent(game) + keyParameter()
+		var ws = new WebSocket(url)
+		ws.onopen = function() {
+			socket = ws
+		}
+		ws.onmessage = function(event) {
+			var message = JSON.parse(event.data)
+			if (message.event == 'state') {
+				state = message.state
+				update()
+			} else if (message.event == 'error') {
+				alert("Update request failed!\n" + message.message)
+			}
+		}
+		ws.onclose = function() {
+			socket = null
+			listenForEvents(game)
+		}
+	}
+
+	connect(getParameter('game'))
 
 })()
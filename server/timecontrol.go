@@ -0,0 +1,198 @@
+package server
+
+import "errors"
+import "fmt"
+import "log"
+import "time"
+
+// Kinds of time control that can be chosen when creating a game.
+const (
+	time_control_none           = ""
+	time_control_sudden_death   = "sudden death"   // Initial only
+	time_control_fischer        = "fischer"        // Initial plus Increment per move
+	time_control_bronstein      = "bronstein"      // Initial; time used up to Delay is given back
+	time_control_byoyomi        = "byoyomi"        // Initial, then Periods of Period each
+	time_control_correspondence = "correspondence" // Days per move
+)
+
+// A time control as chosen at /create.  Times are in seconds.
+type TimeControl struct {
+	Type      string  `json:"type"`
+	Initial   float64 `json:"initial,omitempty"`
+	Increment float64 `json:"increment,omitempty"`
+	Delay     float64 `json:"delay,omitempty"`
+	Periods   int     `json:"periods,omitempty"`
+	Period    float64 `json:"period,omitempty"`
+	Days      int     `json:"days,omitempty"`
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func (tc *TimeControl) validate() error {
+	if tc.Initial < 0 || tc.Increment < 0 || tc.Delay < 0 || tc.Periods < 0 || tc.Period < 0 || tc.Days < 0 {
+		return errors.New("Time control values must not be negative.")
+	}
+	switch tc.Type {
+	case time_control_none:
+		return nil
+	case time_control_sudden_death, time_control_fischer, time_control_bronstein:
+		if tc.Initial <= 0 {
+			return errors.New("Initial time must be positive.")
+		}
+	case time_control_byoyomi:
+		if tc.Periods <= 0 || tc.Period <= 0 {
+			return errors.New("Byo-yomi needs a positive number of periods and period length.")
+		}
+	case time_control_correspondence:
+		if tc.Days <= 0 {
+			return errors.New("Correspondence needs a positive number of days per move.")
+		}
+	default:
+		return fmt.Errorf("Unknown time control type: %q", tc.Type)
+	}
+	return nil
+}
+
+// Sets up the clocks of a new game.
+func (g *game) startClocks() {
+	for p := range g.TimeLeft {
+		switch g.TimeControl.Type {
+		case time_control_correspondence:
+			g.TimeLeft[p] = time.Duration(g.TimeControl.Days) * 24 * time.Hour
+		default:
+			g.TimeLeft[p] = seconds(g.TimeControl.Initial)
+		}
+		g.PeriodsLeft[p] = g.TimeControl.Periods
+	}
+}
+
+// Returns when the player to move runs out of time.  Returns false if the
+// clock is not running: without a time control, before the first move, and
+// after the game has ended.
+func (g *game) deadline() (time.Time, bool) {
+	if g.TimeControl.Type == time_control_none || g.LastTime.IsZero() || g.over() {
+		return time.Time{}, false
+	}
+	p := g.State.Next()
+	left := g.TimeLeft[p]
+	if g.TimeControl.Type == time_control_byoyomi {
+		left += time.Duration(g.PeriodsLeft[p]) * seconds(g.TimeControl.Period)
+	}
+	return g.LastTime.Add(left), true
+}
+
+// Returns the time left for both players at the given time, and the number
+// of byo-yomi periods left.  For byo-yomi, once the main time is used up the
+// time left is that of the current period.
+func (g *game) timeLeft(now time.Time) (left [2]time.Duration, periods [2]int) {
+	left, periods = g.TimeLeft, g.PeriodsLeft
+	period := seconds(g.TimeControl.Period)
+	for p := range left {
+		elapsed := time.Duration(0)
+		if p == g.State.Next() && !g.LastTime.IsZero() && !g.over() {
+			elapsed = now.Sub(g.LastTime)
+		}
+		if g.TimeControl.Type == time_control_byoyomi && periods[p] > 0 && elapsed >= left[p] {
+			overtime := elapsed - left[p]
+			used := int(overtime / period)
+			if periods[p] -= used; periods[p] <= 0 {
+				left[p], periods[p] = 0, 0
+				continue
+			}
+			left[p] = period - (overtime - time.Duration(used)*period)
+		} else {
+			left[p] -= elapsed
+		}
+		if left[p] < 0 || periods[p] < 0 {
+			left[p], periods[p] = 0, 0
+		}
+	}
+	return
+}
+
+// Updates the clock of the player who just moved, now that they spent the
+// given time on their move.
+func (g *game) pressClock(player int, spent time.Duration) {
+	tc := &g.TimeControl
+	switch tc.Type {
+	case time_control_fischer:
+		g.TimeLeft[player] += seconds(tc.Increment) - spent
+	case time_control_bronstein:
+		if delay := seconds(tc.Delay); spent > delay {
+			g.TimeLeft[player] += delay - spent
+		}
+	case time_control_byoyomi:
+		if spent <= g.TimeLeft[player] {
+			g.TimeLeft[player] -= spent
+		} else {
+			// Completed periods are used up; the current one starts over.
+			overtime := spent - g.TimeLeft[player]
+			g.PeriodsLeft[player] -= int(overtime / seconds(tc.Period))
+			g.TimeLeft[player] = 0
+		}
+	case time_control_correspondence:
+		g.TimeLeft[player] = time.Duration(tc.Days) * 24 * time.Hour
+	case time_control_sudden_death:
+		g.TimeLeft[player] -= spent
+	}
+}
+
+// Charges the player to move for the time since their clock started, when
+// their turn is interrupted without a move, as by a takeback.  Unlike
+// pressClock, no increment or delay is given.
+func (g *game) chargeClock(now time.Time) {
+	if g.LastTime.IsZero() {
+		return
+	}
+	p := g.State.Next()
+	spent := now.Sub(g.LastTime)
+	g.TimeUsed[p] += spent
+	switch g.TimeControl.Type {
+	case time_control_sudden_death, time_control_fischer, time_control_bronstein:
+		g.TimeLeft[p] -= spent
+	case time_control_byoyomi:
+		g.pressClock(p, spent)
+	}
+	g.LastTime = now
+}
+
+// Ends the game if the player to move ran out of time.  The game mutex must
+// be held.  Returns whether the game ended.
+func (g *game) checkFlag(db Saver, now time.Time) bool {
+	if deadline, ok := g.deadline(); !ok || now.Before(deadline) {
+		return false
+	}
+	p := g.State.Next()
+	g.TimeLeft[p] = 0
+	g.PeriodsLeft[p] = 0
+	g.TimeUsed[p] += now.Sub(g.LastTime)
+	g.LastTime = now
+	g.end(db, -g.State.NextPlayer(), color_names[p]+" ran out of time")
+	return true
+}
+
+// Arranges for the game to end when the player to move runs out of time,
+// even if no further requests arrive.  The game mutex must be held.
+//
+// The game is saved using db when the flag falls.  Where db is only valid
+// during a request (as on App Engine), saving may fail, but the result is
+// also detected when the game is next accessed.
+func (g *game) scheduleFlagFall(db Saver) {
+	if g.flag_timer != nil {
+		g.flag_timer.Stop()
+		g.flag_timer = nil
+	}
+	deadline, ok := g.deadline()
+	if !ok {
+		return
+	}
+	g.flag_timer = time.AfterFunc(deadline.Sub(time.Now()), func() {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		if g.checkFlag(db, time.Now()) {
+			log.Printf("Game %s: %s", g.id, g.Result.Reason)
+		}
+	})
+}
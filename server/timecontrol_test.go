@@ -0,0 +1,162 @@
+package server
+
+import "ayu"
+import "container/list"
+import "testing"
+import "time"
+
+var fischer = TimeControl{Type: time_control_fischer, Initial: 60, Increment: 5}
+var bronstein = TimeControl{Type: time_control_bronstein, Initial: 60, Delay: 5}
+var sudden_death = TimeControl{Type: time_control_sudden_death, Initial: 60}
+var byoyomi = TimeControl{Type: time_control_byoyomi, Initial: 60, Periods: 3, Period: 10}
+var correspondence = TimeControl{Type: time_control_correspondence, Days: 2}
+
+func newTestGame(tc TimeControl) *game {
+	g := &game{State: ayu.CreateState(ayu.DefaultSize), TimeControl: tc, waiting: list.New()}
+	g.startClocks()
+	return g
+}
+
+func TestPressClock(t *testing.T) {
+	tests := []struct {
+		tc           TimeControl
+		overtime     bool // whether the main time is used up already
+		periods      int  // before the move, with byo-yomi
+		spent        time.Duration
+		left         time.Duration
+		periods_left int
+	}{
+		{sudden_death, false, 0, 10 * time.Second, 50 * time.Second, 0},
+		{fischer, false, 0, 10 * time.Second, 55 * time.Second, 0},
+		{fischer, false, 0, 2 * time.Second, 63 * time.Second, 0},
+		{bronstein, false, 0, 3 * time.Second, 60 * time.Second, 0},
+		{bronstein, false, 0, 10 * time.Second, 55 * time.Second, 0},
+		{byoyomi, false, 3, 30 * time.Second, 30 * time.Second, 3},
+		// Into overtime: one period used up, the second one starts over.
+		{byoyomi, false, 3, 75 * time.Second, 0, 2},
+		// In overtime, a move within the period uses none up.
+		{byoyomi, true, 2, 9 * time.Second, 0, 2},
+		{byoyomi, true, 2, 15 * time.Second, 0, 1},
+		{correspondence, false, 0, 30 * time.Hour, 48 * time.Hour, 0},
+	}
+	for _, test := range tests {
+		g := newTestGame(test.tc)
+		if test.overtime {
+			g.TimeLeft[0] = 0
+		}
+		g.PeriodsLeft[0] = test.periods
+		g.pressClock(0, test.spent)
+		if g.TimeLeft[0] != test.left || g.PeriodsLeft[0] != test.periods_left {
+			t.Errorf("%+v, spent %s: got %s and %d periods, expected %s and %d periods", test.tc, test.spent,
+				g.TimeLeft[0], g.PeriodsLeft[0], test.left, test.periods_left)
+		}
+		if g.TimeLeft[1] != newTestGame(test.tc).TimeLeft[1] || g.PeriodsLeft[1] != test.tc.Periods {
+			t.Errorf("%+v: the opponent's clock changed", test.tc)
+		}
+	}
+}
+
+func TestTimeLeft(t *testing.T) {
+	tests := []struct {
+		tc      TimeControl
+		elapsed time.Duration
+		left    time.Duration
+		periods int
+	}{
+		{sudden_death, 10 * time.Second, 50 * time.Second, 0},
+		{sudden_death, 70 * time.Second, 0, 0},
+		// The increment is only added once the move is made.
+		{fischer, 10 * time.Second, 50 * time.Second, 0},
+		{bronstein, 10 * time.Second, 50 * time.Second, 0},
+		{byoyomi, 30 * time.Second, 30 * time.Second, 3},
+		{byoyomi, 60 * time.Second, 10 * time.Second, 3},
+		{byoyomi, 75 * time.Second, 5 * time.Second, 2},
+		{byoyomi, 85 * time.Second, 5 * time.Second, 1},
+		{byoyomi, 95 * time.Second, 0, 0},
+		{correspondence, 30 * time.Hour, 18 * time.Hour, 0},
+	}
+	start := time.Now()
+	for _, test := range tests {
+		g := newTestGame(test.tc)
+		g.LastTime = start
+		left, periods := g.timeLeft(start.Add(test.elapsed))
+		if left[0] != test.left || periods[0] != test.periods {
+			t.Errorf("%+v after %s: got %s and %d periods, expected %s and %d periods", test.tc, test.elapsed,
+				left[0], periods[0], test.left, test.periods)
+		}
+		if left[1] != g.TimeLeft[1] || periods[1] != g.PeriodsLeft[1] {
+			t.Errorf("%+v: the opponent's clock is running", test.tc)
+		}
+	}
+}
+
+func TestCheckFlag(t *testing.T) {
+	tests := []struct {
+		tc        TimeControl
+		flag_fall time.Duration
+	}{
+		{sudden_death, 60 * time.Second},
+		{fischer, 60 * time.Second},
+		{bronstein, 60 * time.Second},
+		// The main time and all periods.
+		{byoyomi, 90 * time.Second},
+		{correspondence, 48 * time.Hour},
+	}
+	start := time.Now()
+	for _, test := range tests {
+		g := newTestGame(test.tc)
+		if _, ok := g.deadline(); ok {
+			t.Errorf("%+v: clock running before the first move", test.tc)
+		}
+		g.LastTime = start
+		if deadline, ok := g.deadline(); !ok || !deadline.Equal(start.Add(test.flag_fall)) {
+			t.Errorf("%+v: deadline %s after the start, expected %s", test.tc, deadline.Sub(start), test.flag_fall)
+		}
+		if g.checkFlag(nil, start.Add(test.flag_fall-time.Millisecond)) || g.Result != nil {
+			t.Errorf("%+v: flag fell early", test.tc)
+		}
+		if !g.checkFlag(nil, start.Add(test.flag_fall)) {
+			t.Errorf("%+v: flag did not fall", test.tc)
+			continue
+		}
+		if g.Result == nil || g.Result.Winner != -1 || g.Result.Reason != "White ran out of time" {
+			t.Errorf("%+v: got result %+v", test.tc, g.Result)
+		}
+		if g.TimeLeft[0] != 0 || g.PeriodsLeft[0] != 0 || g.TimeUsed[0] != test.flag_fall {
+			t.Errorf("%+v: got %s left and %s used", test.tc, g.TimeLeft[0], g.TimeUsed[0])
+		}
+		if _, ok := g.deadline(); ok {
+			t.Errorf("%+v: clock still running after the game ended", test.tc)
+		}
+	}
+}
+
+// A takeback charges the time used without an increment or delay.
+func TestChargeClock(t *testing.T) {
+	tests := []struct {
+		tc      TimeControl
+		spent   time.Duration
+		left    time.Duration
+		periods int
+	}{
+		{sudden_death, 10 * time.Second, 50 * time.Second, 0},
+		{fischer, 10 * time.Second, 50 * time.Second, 0},
+		{bronstein, 3 * time.Second, 57 * time.Second, 0},
+		{byoyomi, 75 * time.Second, 0, 2},
+		{correspondence, 30 * time.Hour, 48 * time.Hour, 0},
+	}
+	start := time.Now()
+	for _, test := range tests {
+		g := newTestGame(test.tc)
+		g.LastTime = start
+		now := start.Add(test.spent)
+		g.chargeClock(now)
+		if g.TimeLeft[0] != test.left || g.PeriodsLeft[0] != test.periods {
+			t.Errorf("%+v, spent %s: got %s and %d periods, expected %s and %d periods", test.tc, test.spent,
+				g.TimeLeft[0], g.PeriodsLeft[0], test.left, test.periods)
+		}
+		if g.TimeUsed[0] != test.spent || !g.LastTime.Equal(now) {
+			t.Errorf("%+v: got %s used since %s", test.tc, g.TimeUsed[0], g.LastTime)
+		}
+	}
+}
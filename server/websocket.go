@@ -0,0 +1,283 @@
+package server
+
+import "ayu"
+import "bufio"
+import "crypto/sha1"
+import "encoding/base64"
+import "encoding/binary"
+import "encoding/json"
+import "errors"
+import "io"
+import "log"
+import "net"
+import "net/http"
+import "strings"
+import "sync"
+import "time"
+
+// Minimal WebSocket (RFC 6455) support, enough to push game updates to
+// browsers and receive moves from them.  Messages are JSON objects.
+//
+// Sent by the server:
+//   {"event": "state", "state": <same as /poll>}  on connect and after changes
+//   {"event": "end", "winner": +1, -1 or 0, "reason": ...}  once the game is over
+//   {"event": "error", "status": 409, "message": "Wrong Version"}
+//
+// Sent by the client:
+//   {"version": <int>, "key": <player key>, "move": [[r1,c1],[r2,c2]]}
+//   {"key": <player key>, "action": "resign"} (or any other action)
+//   {"chat": <text>, "channel": "players" or "spectators", "key": ..., "name": ...}
+
+const websocket_guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Largest message we accept from a client.
+const max_websocket_message = 64 << 10
+
+const (
+	opcode_continuation = 0
+	opcode_text         = 1
+	opcode_close        = 8
+	opcode_ping         = 9
+	opcode_pong         = 10
+)
+
+type webSocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mutex  sync.Mutex // must be held while writing to conn
+}
+
+// Performs the opening handshake and takes over the connection.  On
+// failure, the request has been answered with an error, or the connection
+// closed if it was already taken over, so the caller can just return.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*webSocket, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "Bad Request\nNot a WebSocket version 13 request.", 400)
+		return nil, errors.New("Not a WebSocket version 13 request.")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Bad Request\nMissing Sec-WebSocket-Key.", 400)
+		return nil, errors.New("Missing Sec-WebSocket-Key.")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Not Implemented\nConnection can't be hijacked.", 501)
+		return nil, errors.New("Connection can't be hijacked.")
+	}
+	// The response writer can't be used once Hijack was called, even if
+	// it failed.
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum([]byte(key + websocket_guid))
+	if _, err := conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &webSocket{conn: conn, reader: rw.Reader}, nil
+}
+
+func (ws *webSocket) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n < 1<<16:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.conn.SetWriteDeadline(time.Now().Add(write_timeout))
+	if _, err := ws.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := ws.conn.Write(payload)
+	return err
+}
+
+func (ws *webSocket) writeJson(obj interface{}) error {
+	if text, err := json.Marshal(obj); err != nil {
+		return err
+	} else {
+		return ws.writeFrame(opcode_text, text)
+	}
+}
+
+// Reads a single frame, which clients must mask.
+func (ws *webSocket) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(ws.reader, header[:]); err != nil {
+		return
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0f
+	if header[1]&0x80 == 0 {
+		err = errors.New("Unmasked frame from client.")
+		return
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > max_websocket_message {
+		err = errors.New("Frame too large.")
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(ws.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// Reads the next data message, answering pings and reassembling fragmented
+// messages along the way.  Returns io.EOF when the client closes.
+func (ws *webSocket) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case opcode_close:
+			ws.writeFrame(opcode_close, nil)
+			return nil, io.EOF
+		case opcode_ping:
+			if err := ws.writeFrame(opcode_pong, payload); err != nil {
+				return nil, err
+			}
+		case opcode_pong:
+		default:
+			if message = append(message, payload...); len(message) > max_websocket_message {
+				return nil, errors.New("Message too large.")
+			}
+			if fin {
+				return message, nil
+			}
+		}
+	}
+}
+
+func (ws *webSocket) Close() error {
+	return ws.conn.Close()
+}
+
+// Receives moves, other actions and chat messages from the client until the
+// connection is closed.
+func receiveMoves(ws *webSocket, db Saver, id string, game *game, sender string) {
+	for {
+		message, err := ws.readMessage()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("WebSocket for game %s: %s", id, err)
+			}
+			return
+		}
+		var update struct {
+			Version int
+			Key     string
+			Move    ayu.Move
+			Action  string
+			Chat    string
+			Channel string
+			Name    string
+		}
+		if err := json.Unmarshal(message, &update); err != nil {
+			ws.writeJson(map[string]interface{}{
+				"event": "error", "status": 400, "message": err.Error()})
+			continue
+		}
+		game.mutex.Lock()
+		var status int
+		var text string
+		if update.Chat != "" {
+			status, text = game.addChat(db, &chatRequest{
+				Channel: update.Channel, Key: update.Key, Name: update.Name, Text: update.Chat}, sender)
+		} else if update.Action != "" {
+			status, text = game.applyAction(db, update.Key, update.Action)
+		} else {
+			status, text = game.applyMove(db, update.Version, update.Key, update.Move)
+		}
+		game.mutex.Unlock()
+		if status != 200 {
+			ws.writeJson(map[string]interface{}{
+				"event": "error", "status": status, "message": text})
+		}
+	}
+}
+
+func handleSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", 405)
+		return
+	}
+	log.Print("GET /socket")
+
+	id := r.FormValue("game")
+	game := getGame(r, id)
+	if game == nil {
+		http.Error(w, "Not Found", 404)
+		return
+	}
+	db := getDatabase(r)
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Printf("WebSocket for game %s: %s", id, err)
+		return
+	}
+	defer ws.Close()
+
+	closed := make(chan struct{})
+	go func() {
+		receiveMoves(ws, db, id, game, remoteHost(r))
+		close(closed)
+	}()
+
+	// Push the game state whenever it changes, until the client goes away.
+	// The end of the game is announced once, although the state may still
+	// change afterwards, e.g. with chat messages.  Pings keep idle
+	// connections from being dropped by proxies.
+	end_sent := false
+	player := game.playerWithKey(r.FormValue("key")) >= 0
+	game.followUpdates(-1, player, closed, func(revision int, state []byte, result *gameResult) error {
+		err := ws.writeJson(map[string]interface{}{
+			"event": "state", "state": json.RawMessage(state)})
+		if err == nil && result != nil && !end_sent {
+			err = ws.writeJson(map[string]interface{}{
+				"event": "end", "winner": result.Winner, "reason": result.Reason})
+			end_sent = true
+		}
+		return err
+	}, func() error {
+		return ws.writeFrame(opcode_ping, nil)
+	})
+}